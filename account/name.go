@@ -0,0 +1,69 @@
+package account
+
+import (
+	"errors"
+	"strings"
+)
+
+// maxLocalPartLength is the RFC 5321 limit for the local part of an address
+const maxLocalPartLength = 64
+
+// DefaultReservedNames are refused as account names unless overridden in config
+var DefaultReservedNames = []string{
+	"abuse", "admin", "administrator", "hostmaster", "mailer-daemon",
+	"no-reply", "noreply", "postmaster", "root", "security", "webmaster",
+}
+
+var (
+	ErrNameEmpty    = errors.New("name must not be empty")
+	ErrNameTooLong  = errors.New("name must be at most 64 characters")
+	ErrNameDots     = errors.New("name must not start or end with a dot or contain consecutive dots")
+	ErrNameChar     = errors.New("name contains a character that is not allowed")
+	ErrNameReserved = errors.New("name is reserved")
+)
+
+// NormalizeName lower-cases an account name and validates it as an RFC 5321 dot-string.
+// Quoted local parts are not supported, and '/', '?', '#' and '%' are refused even though
+// RFC 5321 allows them because the name is also used as a URL path segment.
+func NormalizeName(name string, reserved []string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	if name == "" {
+		return "", ErrNameEmpty
+	}
+	if len(name) > maxLocalPartLength {
+		return "", ErrNameTooLong
+	}
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, "..") {
+		return "", ErrNameDots
+	}
+
+	for _, r := range name {
+		if r != '.' && !isAtext(r) {
+			return "", ErrNameChar
+		}
+	}
+
+	if len(reserved) == 0 {
+		reserved = DefaultReservedNames
+	}
+	for _, r := range reserved {
+		if strings.EqualFold(name, r) {
+			return "", ErrNameReserved
+		}
+	}
+
+	return name, nil
+}
+
+// isAtext reports whether r is an RFC 5322 atext character that is also safe in a URL path
+func isAtext(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	case strings.ContainsRune("!$&'*+-=^_`{|}~", r):
+		return true
+	default:
+		return false
+	}
+}
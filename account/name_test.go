@@ -0,0 +1,40 @@
+package account
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		reserved []string
+		expected string
+		err      error
+	}{
+		{name: "simple", input: "signup-test-jane", expected: "signup-test-jane"},
+		{name: "lower cased and trimmed", input: " Jane.Doe ", expected: "jane.doe"},
+		{name: "special atext", input: "a+b_c=d", expected: "a+b_c=d"},
+		{name: "empty", input: "  ", err: ErrNameEmpty},
+		{name: "too long", input: strings.Repeat("a", 65), err: ErrNameTooLong},
+		{name: "leading dot", input: ".jane", err: ErrNameDots},
+		{name: "consecutive dots", input: "jane..doe", err: ErrNameDots},
+		{name: "at sign", input: "jane@doe", err: ErrNameChar},
+		{name: "slash", input: "jane/doe", err: ErrNameChar},
+		{name: "space", input: "jane doe", err: ErrNameChar},
+		{name: "default reserved", input: "Postmaster", err: ErrNameReserved},
+		{name: "custom reserved", input: "billing", reserved: []string{"billing"}, err: ErrNameReserved},
+		{name: "custom list replaces defaults", input: "admin", reserved: []string{"billing"}, expected: "admin"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name, err := NormalizeName(tc.input, tc.reserved)
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.expected, name)
+		})
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/galihrivanto/kotak/fault"
+
 	"github.com/spf13/viper"
 )
 
@@ -52,6 +54,12 @@ type HttpServer struct {
 	// Static file configuration
 	StaticDir string `mapstructure:"static_dir" yaml:"static_dir"`
 	StaticURL string `mapstructure:"static_url" yaml:"static_url"`
+
+	// RemoteContent sets how remote images in HTML emails are rendered: block, proxy or allow
+	RemoteContent string `mapstructure:"remote_content" yaml:"remote_content"`
+
+	// AdminToken protects the admin API, which is disabled when empty
+	AdminToken string `mapstructure:"admin_token" yaml:"admin_token"`
 }
 
 // SmtpServer configuration
@@ -63,14 +71,156 @@ type SmtpServer struct {
 	Username string `mapstructure:"username" yaml:"username"`
 	Password string `mapstructure:"password" yaml:"password"`
 	MaxSize  int    `mapstructure:"max_size" yaml:"max_size"`
+
+	// TLS enables STARTTLS using CertFile and KeyFile, TLSPort adds an implicit TLS (SMTPS) listener
+	TLS           bool   `mapstructure:"tls" yaml:"tls"`
+	CertFile      string `mapstructure:"cert_file" yaml:"cert_file"`
+	KeyFile       string `mapstructure:"key_file" yaml:"key_file"`
+	TLSPort       string `mapstructure:"tls_port" yaml:"tls_port"`
+	TLSMinVersion string `mapstructure:"tls_min_version" yaml:"tls_min_version"`
+	// TLSRequired rejects mail commands until the client has started TLS
+	TLSRequired bool `mapstructure:"tls_required" yaml:"tls_required"`
+
+	// Auth enables SMTP AUTH against Credentials, and Username/Password when set.
+	// AuthRequired rejects mail from clients that have not authenticated.
+	Auth           bool     `mapstructure:"auth" yaml:"auth"`
+	AuthRequired   bool     `mapstructure:"auth_required" yaml:"auth_required"`
+	AuthMechanisms []string `mapstructure:"auth_mechanisms" yaml:"auth_mechanisms"`
+	// AuthAllowInsecure offers PLAIN and LOGIN on connections without TLS
+	AuthAllowInsecure bool         `mapstructure:"auth_allow_insecure" yaml:"auth_allow_insecure"`
+	Credentials       []Credential `mapstructure:"credentials" yaml:"credentials"`
+
+	// CatchAll creates accounts on first delivery to an unknown recipient,
+	// optionally only for local parts matching CatchAllPattern
+	CatchAll        bool   `mapstructure:"catch_all" yaml:"catch_all"`
+	CatchAllPattern string `mapstructure:"catch_all_pattern" yaml:"catch_all_pattern"`
+	// CatchAllAccess sets who can use the API of accounts without their own token, such as
+	// catch-all inboxes: token (default) requires CatchAllToken, read lets anyone read them
+	// and open lets anyone read, change and delete them
+	CatchAllAccess string `mapstructure:"catch_all_access" yaml:"catch_all_access"`
+	CatchAllToken  string `mapstructure:"catch_all_token" yaml:"catch_all_token"`
+
+	// SubAddressDelimiters separate the account from a tag in the local part, e.g. "+" for alice+tag
+	SubAddressDelimiters []string `mapstructure:"sub_address_delimiters" yaml:"sub_address_delimiters"`
+
+	// Domains accepted for delivery, defaults to Hostname alone when empty
+	Domains []Domain `mapstructure:"domains" yaml:"domains"`
+
+	// RateLimit protects the server from senders flooding it
+	RateLimit RateLimit `mapstructure:"rate_limit" yaml:"rate_limit"`
+
+	// Faults is the initial fault injection setup, it can be changed at runtime through the admin API
+	Faults fault.Settings `mapstructure:"faults" yaml:"faults"`
+}
+
+// RateLimit configures SMTP limits, zero disables a limit.
+// Messages are counted per minute, connections are counted while open.
+type RateLimit struct {
+	MessagesPerIP        int `mapstructure:"messages_per_ip" yaml:"messages_per_ip"`
+	MessagesPerSender    int `mapstructure:"messages_per_sender" yaml:"messages_per_sender"`
+	MessagesPerRecipient int `mapstructure:"messages_per_recipient" yaml:"messages_per_recipient"`
+	MaxConnections       int `mapstructure:"max_connections" yaml:"max_connections"`
+	MaxConnectionsPerIP  int `mapstructure:"max_connections_per_ip" yaml:"max_connections_per_ip"`
+}
+
+// Credential is an SMTP AUTH username and password
+type Credential struct {
+	Username string `mapstructure:"username" yaml:"username"`
+	Password string `mapstructure:"password" yaml:"password"`
+	// Domains limits the recipient domains the credential may send to, any accepted domain when empty
+	Domains []string `mapstructure:"domains" yaml:"domains"`
+}
+
+// AllCredentials returns the configured credentials, including Username and Password when set
+func (s SmtpServer) AllCredentials() []Credential {
+	var credentials []Credential
+	if s.Username != "" {
+		credentials = append(credentials, Credential{Username: s.Username, Password: s.Password})
+	}
+	return append(credentials, s.Credentials...)
+}
+
+// Domain is the policy of a receiving domain, zero values inherit the global settings
+type Domain struct {
+	Name            string        `mapstructure:"name" yaml:"name"`
+	CatchAll        *bool         `mapstructure:"catch_all" yaml:"catch_all"`
+	CatchAllPattern string        `mapstructure:"catch_all_pattern" yaml:"catch_all_pattern"`
+	CatchAllAccess  string        `mapstructure:"catch_all_access" yaml:"catch_all_access"`
+	CatchAllToken   string        `mapstructure:"catch_all_token" yaml:"catch_all_token"`
+	MaxAge          time.Duration `mapstructure:"max_age" yaml:"max_age"`
+	EmailMaxAge     time.Duration `mapstructure:"email_max_age" yaml:"email_max_age"`
+	MaxTTL          time.Duration `mapstructure:"max_ttl" yaml:"max_ttl"`
+	MaxSize         int           `mapstructure:"max_size" yaml:"max_size"`
+}
+
+// defaultMaxAge is the account lifetime when none is configured
+const defaultMaxAge = 24 * time.Hour
+
+// AccountTTL returns the lifetime of new accounts on the domain
+func (d Domain) AccountTTL() time.Duration {
+	if d.MaxAge <= 0 {
+		return defaultMaxAge
+	}
+	return d.MaxAge
+}
+
+// AccountMaxTTL returns how far into the future an account expiry may be set or extended
+func (d Domain) AccountMaxTTL() time.Duration {
+	if d.MaxTTL <= 0 {
+		return d.AccountTTL()
+	}
+	return d.MaxTTL
+}
+
+// CatchAllEnabled reports whether unknown recipients of the domain get an account on first delivery
+func (d Domain) CatchAllEnabled() bool {
+	return d.CatchAll != nil && *d.CatchAll
+}
+
+// Account is the configuration for account creation
+type Account struct {
+	// IDStyle is either random (default) or words, e.g. brave-otter-42
+	IDStyle    string `mapstructure:"id_style" yaml:"id_style"`
+	IDLength   int    `mapstructure:"id_length" yaml:"id_length"`
+	IDAlphabet string `mapstructure:"id_alphabet" yaml:"id_alphabet"`
+
+	// ReservedNames cannot be requested as custom account names
+	ReservedNames []string `mapstructure:"reserved_names" yaml:"reserved_names"`
 }
 
 // Inbox is the configuration for the inbox setting
 type Inbox struct {
 	CleanupInterval time.Duration `mapstructure:"cleanup_interval" yaml:"cleanup_interval"`
-	MaxAge          time.Duration `mapstructure:"max_age" yaml:"max_age"`
+	// MaxAge is the default lifetime of an account, it is deleted with all its emails once expired
+	MaxAge time.Duration `mapstructure:"max_age" yaml:"max_age"`
+	// MaxTTL bounds the lifetime requested at creation or through extension, defaults to MaxAge
+	MaxTTL time.Duration `mapstructure:"max_ttl" yaml:"max_ttl"`
+	// EmailMaxAge is the lifetime of a single email, zero keeps emails as long as their account
+	EmailMaxAge time.Duration `mapstructure:"email_max_age" yaml:"email_max_age"`
+
+	// MaxMessages and MaxStorage (in bytes) limit each account, zero is unlimited.
+	// QuotaPolicy is either reject (default), answering 552, or evict, deleting the oldest emails.
+	MaxMessages int64  `mapstructure:"max_messages" yaml:"max_messages"`
+	MaxStorage  int64  `mapstructure:"max_storage" yaml:"max_storage"`
+	QuotaPolicy string `mapstructure:"quota_policy" yaml:"quota_policy"`
+
+	// MarkReadOnOpen marks an email as read when it is fetched, the mark_read query parameter overrides it
+	MarkReadOnOpen bool `mapstructure:"mark_read_on_open" yaml:"mark_read_on_open"`
 }
 
+// Access modes of accounts without their own token
+const (
+	CatchAllAccessToken = "token"
+	CatchAllAccessRead  = "read"
+	CatchAllAccessOpen  = "open"
+)
+
+// Quota policies
+const (
+	QuotaReject = "reject"
+	QuotaEvict  = "evict"
+)
+
 // Config is the configuration for the application
 type Config struct {
 	Database   Database   `mapstructure:"database" yaml:"database"`
@@ -78,6 +228,59 @@ type Config struct {
 	SmtpServer SmtpServer `mapstructure:"smtp_server" yaml:"smtp_server"`
 	Logger     Logger     `mapstructure:"logger" yaml:"logger"`
 	Inbox      Inbox      `mapstructure:"inbox" yaml:"inbox"`
+	Account    Account    `mapstructure:"account" yaml:"account"`
+}
+
+// Domains returns the accepted domains with global settings filled in.
+// The first domain is the default one for new accounts.
+func (c *Config) Domains() []Domain {
+	domains := c.SmtpServer.Domains
+	if len(domains) == 0 {
+		domains = []Domain{{Name: c.SmtpServer.Hostname}}
+	}
+
+	resolved := make([]Domain, 0, len(domains))
+	for _, d := range domains {
+		d.Name = strings.ToLower(strings.TrimSpace(d.Name))
+		if d.CatchAll == nil {
+			catchAll := c.SmtpServer.CatchAll
+			d.CatchAll = &catchAll
+		}
+		if d.CatchAllPattern == "" {
+			d.CatchAllPattern = c.SmtpServer.CatchAllPattern
+		}
+		if d.CatchAllAccess == "" {
+			d.CatchAllAccess = c.SmtpServer.CatchAllAccess
+		}
+		if d.CatchAllToken == "" {
+			d.CatchAllToken = c.SmtpServer.CatchAllToken
+		}
+		if d.MaxAge <= 0 {
+			d.MaxAge = c.Inbox.MaxAge
+		}
+		if d.EmailMaxAge <= 0 {
+			d.EmailMaxAge = c.Inbox.EmailMaxAge
+		}
+		if d.MaxTTL <= 0 {
+			d.MaxTTL = c.Inbox.MaxTTL
+		}
+		if d.MaxSize <= 0 {
+			d.MaxSize = c.SmtpServer.MaxSize
+		}
+		resolved = append(resolved, d)
+	}
+
+	return resolved
+}
+
+// Domain returns the resolved policy of an accepted domain
+func (c *Config) Domain(name string) (Domain, bool) {
+	for _, d := range c.Domains() {
+		if strings.EqualFold(d.Name, name) {
+			return d, true
+		}
+	}
+	return Domain{}, false
 }
 
 // Load loads the configuration from the given viper instance
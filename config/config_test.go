@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
@@ -123,3 +124,45 @@ func TestLoadConfigError(t *testing.T) {
 		Load("nonexistent.yaml")
 	})
 }
+
+func TestDomains(t *testing.T) {
+	enabled := true
+
+	cfg := &Config{
+		SmtpServer: SmtpServer{
+			Hostname:        "kotak.local",
+			MaxSize:         1024,
+			CatchAllPattern: "^ci-",
+		},
+		Inbox: Inbox{MaxAge: time.Hour},
+	}
+
+	// Hostname is the only domain by default
+	domains := cfg.Domains()
+	assert.Len(t, domains, 1)
+	assert.Equal(t, "kotak.local", domains[0].Name)
+	assert.False(t, domains[0].CatchAllEnabled())
+	assert.Equal(t, time.Hour, domains[0].MaxAge)
+	assert.Equal(t, 1024, domains[0].MaxSize)
+
+	cfg.SmtpServer.Domains = []Domain{
+		{Name: "A.example.com"},
+		{Name: "b.example.com", CatchAll: &enabled, MaxAge: time.Minute, MaxSize: 2048},
+	}
+
+	domains = cfg.Domains()
+	assert.Len(t, domains, 2)
+	assert.Equal(t, "a.example.com", domains[0].Name)
+	assert.Equal(t, time.Hour, domains[0].MaxAge)
+	assert.True(t, domains[1].CatchAllEnabled())
+	assert.Equal(t, "^ci-", domains[1].CatchAllPattern)
+	assert.Equal(t, time.Minute, domains[1].MaxAge)
+	assert.Equal(t, 2048, domains[1].MaxSize)
+
+	d, ok := cfg.Domain("B.EXAMPLE.COM")
+	assert.True(t, ok)
+	assert.Equal(t, "b.example.com", d.Name)
+
+	_, ok = cfg.Domain("kotak.local")
+	assert.False(t, ok)
+}
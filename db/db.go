@@ -2,10 +2,15 @@ package db
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/galihrivanto/kotak/config"
+	"github.com/galihrivanto/kotak/extract"
 	"github.com/galihrivanto/kotak/log"
 	"github.com/glebarez/sqlite"
 	"gorm.io/driver/mysql"
@@ -17,6 +22,17 @@ type configKey struct{}
 
 var contextKey = configKey{}
 
+var (
+	// ErrAccountExists is returned when creating an account whose ID is already taken
+	ErrAccountExists = errors.New("account already exists")
+	// ErrAccountNotFound is returned when an account does not exist
+	ErrAccountNotFound = errors.New("account not found")
+	// ErrEmailNotFound is returned when an email does not exist in the account
+	ErrEmailNotFound = errors.New("email not found")
+	// ErrCursorNotFound is returned when a page cursor is not an email of the account
+	ErrCursorNotFound = errors.New("cursor email not found")
+)
+
 // DB is a wrapper around gorm.DB
 type DB struct {
 	*gorm.DB
@@ -24,20 +40,113 @@ type DB struct {
 
 // Email represents a stored email
 type Email struct {
-	ID         int64     `gorm:"primaryKey" json:"id"`
-	AccountID  string    `gorm:"index" json:"account_id"`
-	From       string    `json:"from"`
-	To         string    `json:"to"`
-	Subject    string    `json:"subject"`
-	Body       string    `json:"body"`
-	ReceivedAt time.Time `gorm:"autoCreateTime" json:"received_at"`
+	ID        int64      `gorm:"primaryKey" json:"id"`
+	AccountID string     `gorm:"index" json:"account_id"`
+	From      string     `json:"from"`
+	To        string     `json:"to"`
+	Cc        string     `json:"cc,omitempty"`
+	Subject   string     `json:"subject"`
+	Tag       string     `gorm:"index" json:"tag,omitempty"`
+	MessageID string     `json:"message_id,omitempty"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+	Text      string     `json:"text"`
+	HTML      string     `json:"html"`
+	// HTMLText is the visible text of the HTML body, indexed for search instead of the markup
+	HTMLText    string        `json:"-"`
+	Snippet     string        `gorm:"size:255" json:"snippet,omitempty"`
+	Headers     []EmailHeader `gorm:"foreignKey:EmailID;constraint:OnDelete:CASCADE" json:"headers,omitempty"`
+	Attachments []Attachment  `gorm:"foreignKey:EmailID;constraint:OnDelete:CASCADE" json:"attachments,omitempty"`
+	Envelope    Envelope      `gorm:"embedded;embeddedPrefix:envelope_" json:"envelope"`
+	Size        int64         `json:"size"`
+	Raw         *RawMessage   `gorm:"foreignKey:EmailID;constraint:OnDelete:CASCADE" json:"-"`
+	ReceivedAt  time.Time     `gorm:"autoCreateTime" json:"received_at"`
+
+	// Mailbox state set by the reader; READ is reserved in MySQL, hence the column names
+	Read    bool         `gorm:"column:is_read;index" json:"read"`
+	Starred bool         `gorm:"column:is_starred" json:"starred"`
+	Labels  []EmailLabel `gorm:"foreignKey:EmailID;constraint:OnDelete:CASCADE" json:"labels,omitempty"`
+
+	// Extractions are the codes and links found in the email at ingest
+	Extractions []Extraction `gorm:"foreignKey:EmailID;constraint:OnDelete:CASCADE" json:"extractions,omitempty"`
+}
+
+// Extraction is a one-time code or an actionable link found in an email
+type Extraction struct {
+	ID      int64 `gorm:"primaryKey" json:"-"`
+	EmailID int64 `gorm:"index" json:"email_id"`
+	// Kind is one of the extract package kinds: code, verification, reset or unsubscribe
+	Kind  string `gorm:"size:32;index" json:"kind"`
+	Value string `json:"value"`
+	// Text is the anchor text of a link
+	Text string `json:"text,omitempty"`
+}
+
+// EmailLabel is a free-form label attached to an email, serialized as its name
+type EmailLabel struct {
+	ID      int64  `gorm:"primaryKey"`
+	EmailID int64  `gorm:"index;uniqueIndex:idx_email_label"`
+	Name    string `gorm:"size:255;uniqueIndex:idx_email_label"`
+}
+
+// MarshalJSON encodes a label as its plain name
+func (l EmailLabel) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.Name)
+}
+
+// Envelope holds the SMTP transaction details an email was received with
+type Envelope struct {
+	MailFrom string `json:"mail_from"`
+	RcptTo   string `json:"rcpt_to"`
+	ClientIP string `json:"client_ip"`
+	Helo     string `json:"helo"`
+
+	// TLS details of the connection the message was received on
+	TLS            bool   `json:"tls"`
+	TLSVersion     string `json:"tls_version,omitempty"`
+	TLSCipherSuite string `json:"tls_cipher_suite,omitempty"`
+	TLSServerName  string `json:"tls_server_name,omitempty"`
+
+	// AuthUser is the SMTP AUTH identity the client authenticated as
+	AuthUser string `json:"auth_user,omitempty"`
+}
+
+// RawMessage holds the original message data of an email, as received
+type RawMessage struct {
+	EmailID int64 `gorm:"primaryKey;autoIncrement:false"`
+	Data    []byte
+}
+
+// EmailHeader represents a decoded header of a stored email
+type EmailHeader struct {
+	ID      int64  `gorm:"primaryKey" json:"-"`
+	EmailID int64  `gorm:"index" json:"-"`
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+}
+
+// Attachment represents a file attached to a stored email
+type Attachment struct {
+	ID          int64  `gorm:"primaryKey" json:"id"`
+	EmailID     int64  `gorm:"index" json:"email_id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	ContentID   string `json:"content_id,omitempty"`
+	Inline      bool   `json:"inline"`
+	Checksum    string `json:"checksum"`
+	Data        []byte `json:"-"`
 }
 
 // Account represents a temporary email account
 type Account struct {
+	// ID is the local part of the address, unique across all domains as it identifies the account in the API
 	ID        string    `gorm:"primaryKey"`
+	Domain    string    `gorm:"size:255;index"`
+	TokenHash string    `gorm:"size:64"`
 	CreatedAt time.Time `gorm:"autoCreateTime"`
-	Emails    []Email   `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
+	// ExpiresAt is when the account is deleted, accounts created before expiry was recorded have none
+	ExpiresAt *time.Time `gorm:"index"`
+	Emails    []Email    `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
 }
 
 // New initializes the database
@@ -56,80 +165,742 @@ func New(cfg config.Database) (*DB, error) {
 		return nil, fmt.Errorf("unsupported database driver: %s", cfg.Driver)
 	}
 
-	db, err := gorm.Open(dialector, &gorm.Config{})
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, err
 	}
 
 	// Migrate schema
-	err = db.AutoMigrate(&Account{}, &Email{})
+	err = db.AutoMigrate(&Account{}, &Email{}, &EmailHeader{}, &Attachment{}, &RawMessage{}, &EmailLabel{}, &Extraction{})
 	if err != nil {
 		return nil, err
 	}
 
+	if err = backfillText(db); err != nil {
+		return nil, fmt.Errorf("failed to fill in email text: %w", err)
+	}
+
+	if err = migrateSearch(db); err != nil {
+		return nil, fmt.Errorf("failed to create the search index: %w", err)
+	}
+
 	return &DB{db}, nil
 }
 
-// CreateAccount creates a new temporary email account
-func (db *DB) CreateAccount(id string) error {
-	return db.Create(&Account{ID: id}).Error
+// backfillBatch is how many emails a backfill migration loads at once
+const backfillBatch = 500
+
+// backfillText fills in the snippet and the visible HTML text of emails stored before they were derived at ingest
+func backfillText(db *gorm.DB) error {
+	var lastID int64
+	for {
+		var emails []Email
+		err := db.Select("id", "text", "html", "html_text", "snippet").
+			Where("id > ?", lastID).
+			Where("((snippet IS NULL OR snippet = '') AND (text <> '' OR html <> '')) OR (html <> '' AND (html_text IS NULL OR html_text = ''))").
+			Order("id").Limit(backfillBatch).
+			Find(&emails).Error
+		if err != nil || len(emails) == 0 {
+			return err
+		}
+
+		for _, email := range emails {
+			updates := map[string]interface{}{}
+			if email.Snippet == "" {
+				updates["snippet"] = extract.Snippet(email.Text, email.HTML)
+			}
+			if email.HTMLText == "" && email.HTML != "" {
+				updates["html_text"] = extract.HTMLText(email.HTML)
+			}
+			if err := db.Model(&Email{}).Where("id = ?", email.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		lastID = emails[len(emails)-1].ID
+	}
+}
+
+// CreateAccount creates a new temporary email account, replacing an expired account of the same ID
+func (db *DB) CreateAccount(account *Account) error {
+	err := db.Create(account).Error
+	if !errors.Is(err, gorm.ErrDuplicatedKey) {
+		return err
+	}
+
+	// The account holding the ID may only be waiting for cleanup
+	report, err := db.purgeAccounts(func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("id = ? AND expires_at < ?", account.ID, time.Now())
+	})
+	if err != nil {
+		return err
+	}
+	if report.Accounts == 0 {
+		return ErrAccountExists
+	}
+
+	err = db.Create(account).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrAccountExists
+	}
+	return err
 }
 
-// GetAccount retrieves an account by ID
+// unexpired limits a query to accounts that have not expired, expired accounts only wait for cleanup
+func unexpired(tx *gorm.DB) *gorm.DB {
+	return tx.Where("expires_at IS NULL OR expires_at >= ?", time.Now())
+}
+
+// GetAccount retrieves an account by ID, expired accounts are not found
 func (db *DB) GetAccount(id string) (*Account, error) {
 	var account Account
-	if err := db.First(&account, "id = ?", id).Error; err != nil {
+	if err := db.Scopes(unexpired).First(&account, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAccountNotFound
+		}
 		return nil, err
 	}
 	return &account, nil
 }
 
-// StoreEmail stores a new email in the database
-func (db *DB) StoreEmail(accountID, from, to, subject, body string) (int64, error) {
-	email := Email{
-		AccountID: accountID,
-		From:      from,
-		To:        to,
-		Subject:   subject,
-		Body:      body,
+// StoreEmail stores a new email along with its headers and attachments in the database
+func (db *DB) StoreEmail(email *Email) (int64, error) {
+	if email.HTMLText == "" && email.HTML != "" {
+		email.HTMLText = extract.HTMLText(email.HTML)
 	}
-	if err := db.Create(&email).Error; err != nil {
+	if err := db.Create(email).Error; err != nil {
 		return 0, err
 	}
 	return email.ID, nil
 }
 
-// GetEmails retrieves all emails for an account
-func (db *DB) GetEmails(accountID string) ([]Email, error) {
+// EmailFilter narrows down the emails returned by GetEmails
+type EmailFilter struct {
+	// Tag matches the sub-address the email was delivered to
+	Tag string
+	// Read and Starred match the mailbox flags when set
+	Read    *bool
+	Starred *bool
+	// Label matches emails carrying the label
+	Label string
+
+	// From, To and Subject match emails whose field contains the value, ignoring case
+	From    string
+	To      string
+	Subject string
+	// Query matches emails containing all its words in the subject or body, using full-text search
+	Query string
+	// Since and Until bound the time the email was received, Until excluded
+	Since *time.Time
+	Until *time.Time
+	// HasAttachments matches emails with or without attachments when set
+	HasAttachments *bool
+	// Headers match emails carrying all the headers
+	Headers []HeaderFilter
+	// AfterID matches emails stored after the email with this ID
+	AfterID int64
+}
+
+// HeaderFilter matches a header by name, and by a value it contains when Value is set, ignoring case
+type HeaderFilter struct {
+	Name  string
+	Value string
+}
+
+// conditions returns the WHERE clause selecting the emails of an account matching the filter
+func (f EmailFilter) conditions(tx *gorm.DB, accountID string) (string, []interface{}) {
+	conds := []string{"account_id = ?"}
+	args := []interface{}{accountID}
+	if f.Tag != "" {
+		conds = append(conds, "tag = ?")
+		args = append(args, f.Tag)
+	}
+	if f.Read != nil {
+		conds = append(conds, "is_read = ?")
+		args = append(args, *f.Read)
+	}
+	if f.Starred != nil {
+		conds = append(conds, "is_starred = ?")
+		args = append(args, *f.Starred)
+	}
+	if f.Label != "" {
+		conds = append(conds, "id IN (SELECT email_id FROM email_labels WHERE name = ?)")
+		args = append(args, f.Label)
+	}
+
+	for _, field := range [][2]string{{"from", f.From}, {"to", f.To}, {"subject", f.Subject}} {
+		if field[1] != "" {
+			conds = append(conds, fmt.Sprintf("LOWER(%s) LIKE ? ESCAPE '!'", tx.Statement.Quote(field[0])))
+			args = append(args, containsPattern(field[1]))
+		}
+	}
+	if cond, condArgs := searchCondition(tx, f.Query); cond != "" {
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+	}
+	if f.Since != nil {
+		conds = append(conds, "received_at >= ?")
+		args = append(args, *f.Since)
+	}
+	if f.Until != nil {
+		conds = append(conds, "received_at < ?")
+		args = append(args, *f.Until)
+	}
+	if f.HasAttachments != nil {
+		cond := "EXISTS (SELECT 1 FROM attachments WHERE attachments.email_id = emails.id)"
+		if !*f.HasAttachments {
+			cond = "NOT " + cond
+		}
+		conds = append(conds, cond)
+	}
+	if f.AfterID > 0 {
+		conds = append(conds, "id > ?")
+		args = append(args, f.AfterID)
+	}
+	for _, h := range f.Headers {
+		cond := "id IN (SELECT email_id FROM email_headers WHERE LOWER(name) = ?"
+		args = append(args, strings.ToLower(h.Name))
+		if h.Value != "" {
+			cond += " AND LOWER(value) LIKE ? ESCAPE '!'"
+			args = append(args, containsPattern(h.Value))
+		}
+		conds = append(conds, cond+")")
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// EmailSummary is the listing projection of an email, without bodies, headers or attachments
+type EmailSummary struct {
+	ID             int64        `json:"id"`
+	AccountID      string       `json:"account_id"`
+	From           string       `json:"from"`
+	To             string       `json:"to"`
+	Subject        string       `json:"subject"`
+	Tag            string       `json:"tag,omitempty"`
+	Snippet        string       `json:"snippet"`
+	ReceivedAt     time.Time    `json:"received_at"`
+	Size           int64        `json:"size"`
+	HasAttachments bool         `gorm:"->" json:"has_attachments"`
+	Read           bool         `gorm:"column:is_read" json:"read"`
+	Starred        bool         `gorm:"column:is_starred" json:"starred"`
+	Labels         []EmailLabel `gorm:"foreignKey:EmailID" json:"labels,omitempty"`
+}
+
+// TableName maps summaries onto the emails table
+func (EmailSummary) TableName() string {
+	return "emails"
+}
+
+// Sort columns of email listings
+const (
+	SortReceivedAt = "received_at"
+	SortSize       = "size"
+	SortSubject    = "subject"
+	SortFrom       = "from"
+)
+
+// EmailPage selects a page of an email listing. Before and After are email IDs:
+// After returns the emails following that email in the listing order, Before the ones preceding it.
+type EmailPage struct {
+	Limit  int
+	Before int64
+	After  int64
+	// Sort is one of the Sort constants, received_at when empty
+	Sort string
+	// Ascending lists oldest, smallest or alphabetically first emails first
+	Ascending bool
+}
+
+// EmailList is a page of email summaries
+type EmailList struct {
+	Emails []EmailSummary
+	// HasMore reports whether more emails follow in the direction the page was taken
+	HasMore bool
+}
+
+// ListEmails retrieves a page of email summaries of an account matching the filter.
+// It returns ErrCursorNotFound when the page cursor is not an email of the account.
+func (db *DB) ListEmails(accountID string, filter EmailFilter, page EmailPage) (*EmailList, error) {
+	column := page.Sort
+	switch column {
+	case "":
+		column = SortReceivedAt
+	case SortReceivedAt, SortSize, SortSubject, SortFrom:
+	default:
+		return nil, fmt.Errorf("unsupported sort column %q", page.Sort)
+	}
+	column = db.Statement.Quote(column)
+
+	// Walking backwards from a before cursor reverses the order, the page is flipped afterwards
+	ascending := page.Ascending
+	if page.Before > 0 {
+		ascending = !ascending
+	}
+	direction, compare := "DESC", "<"
+	if ascending {
+		direction, compare = "ASC", ">"
+	}
+
+	query, args := filter.conditions(db.DB, accountID)
+	tx := db.Select("id", "account_id", "from", "to", "subject", "tag", "snippet", "received_at", "size", "is_read", "is_starred",
+		"EXISTS (SELECT 1 FROM attachments WHERE attachments.email_id = emails.id) AS has_attachments").
+		Where(query, args...)
+
+	if cursor := max(page.Before, page.After); cursor > 0 {
+		var count int64
+		if err := db.Model(&Email{}).Where("id = ? AND account_id = ?", cursor, accountID).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, ErrCursorNotFound
+		}
+		tx = tx.Where(fmt.Sprintf("(%s, id) %s (SELECT %s, id FROM emails WHERE id = ? AND account_id = ?)", column, compare, column), cursor, accountID)
+	}
+
+	if page.Limit > 0 {
+		// One extra row tells whether another page follows
+		tx = tx.Limit(page.Limit + 1)
+	}
+
+	var emails []EmailSummary
+	err := tx.Preload("Labels", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("name ASC")
+	}).Order(fmt.Sprintf("%s %s, id %s", column, direction, direction)).
+		Find(&emails).Error
+	if err != nil {
+		return nil, err
+	}
+
+	list := &EmailList{Emails: emails}
+	if page.Limit > 0 && len(emails) > page.Limit {
+		list.Emails, list.HasMore = emails[:page.Limit], true
+	}
+	if page.Before > 0 {
+		slices.Reverse(list.Emails)
+	}
+	return list, nil
+}
+
+// GetEmails retrieves all emails for an account matching the filter
+func (db *DB) GetEmails(accountID string, filter EmailFilter) ([]Email, error) {
 	var emails []Email
-	if err := db.Where("account_id = ?", accountID).Order("received_at DESC").Find(&emails).Error; err != nil {
+	query, args := filter.conditions(db.DB, accountID)
+	err := db.Preload("Labels", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("name ASC")
+	}).Where(query, args...).Order("received_at DESC").Find(&emails).Error
+	if err != nil {
 		return nil, err
 	}
 	return emails, nil
 }
 
-// GetEmail retrieves a specific email
+// GetEmail retrieves a specific email including its headers and attachment metadata
 func (db *DB) GetEmail(id int64, accountID string) (*Email, error) {
 	var email Email
-	if err := db.Where("id = ? AND account_id = ?", id, accountID).First(&email).Error; err != nil {
+	err := db.Preload("Headers", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("id ASC")
+	}).Preload("Attachments", func(tx *gorm.DB) *gorm.DB {
+		return tx.Omit("Data").Order("id ASC")
+	}).Preload("Labels", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("name ASC")
+	}).Where("id = ? AND account_id = ?", id, accountID).First(&email).Error
+	if err != nil {
 		return nil, err
 	}
 	return &email, nil
 }
 
-// AccountExists checks if an account exists
+// GetExtractions retrieves the codes and links found in an email, in the order they were found
+func (db *DB) GetExtractions(emailID int64, accountID string) ([]Extraction, error) {
+	var count int64
+	if err := db.Model(&Email{}).Where("id = ? AND account_id = ?", emailID, accountID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrEmailNotFound
+	}
+
+	var extractions []Extraction
+	if err := db.Where("email_id = ?", emailID).Order("id ASC").Find(&extractions).Error; err != nil {
+		return nil, err
+	}
+	return extractions, nil
+}
+
+// LatestExtraction retrieves the first extraction of the kind in the most recent email matching the filter,
+// nil when there is none
+func (db *DB) LatestExtraction(accountID, kind string, filter EmailFilter) (*Extraction, error) {
+	query, args := filter.conditions(db.DB, accountID)
+
+	var extractions []Extraction
+	err := db.Where("kind = ? AND email_id IN (?)", kind, db.Model(&Email{}).Select("id").Where(query, args...)).
+		Order("email_id DESC, id ASC").
+		Limit(1).
+		Find(&extractions).Error
+	if err != nil || len(extractions) == 0 {
+		return nil, err
+	}
+	return &extractions[0], nil
+}
+
+// GetRawMessage retrieves the original message data of an email
+func (db *DB) GetRawMessage(emailID int64, accountID string) (*RawMessage, error) {
+	var raw RawMessage
+	err := db.Where("email_id = ? AND email_id IN (?)", emailID, db.Model(&Email{}).Select("id").Where("account_id = ?", accountID)).
+		First(&raw).Error
+	if err != nil {
+		return nil, err
+	}
+	return &raw, nil
+}
+
+// GetAttachments retrieves attachment metadata of an email, without contents
+func (db *DB) GetAttachments(emailID int64, accountID string) ([]Attachment, error) {
+	var attachments []Attachment
+	err := db.Omit("Data").
+		Where("email_id = ? AND email_id IN (?)", emailID, db.Model(&Email{}).Select("id").Where("account_id = ?", accountID)).
+		Order("id ASC").
+		Find(&attachments).Error
+	if err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// GetAttachment retrieves a specific attachment including its contents
+func (db *DB) GetAttachment(id, emailID int64, accountID string) (*Attachment, error) {
+	var attachment Attachment
+	err := db.Where("id = ? AND email_id = ? AND email_id IN (?)", id, emailID, db.Model(&Email{}).Select("id").Where("account_id = ?", accountID)).
+		First(&attachment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// EmailUpdate changes the mailbox state of an email, nil fields are left as they are
+type EmailUpdate struct {
+	Read    *bool
+	Starred *bool
+	// Labels replaces all labels, AddLabels and RemoveLabels change them one by one
+	Labels       *[]string
+	AddLabels    []string
+	RemoveLabels []string
+}
+
+// UpdateEmail applies an update to an email of an account
+func (db *DB) UpdateEmail(id int64, accountID string, update EmailUpdate) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&Email{}).Where("id = ? AND account_id = ?", id, accountID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrEmailNotFound
+		}
+
+		flags := map[string]interface{}{}
+		if update.Read != nil {
+			flags["is_read"] = *update.Read
+		}
+		if update.Starred != nil {
+			flags["is_starred"] = *update.Starred
+		}
+		if len(flags) > 0 {
+			if err := tx.Model(&Email{}).Where("id = ?", id).Updates(flags).Error; err != nil {
+				return err
+			}
+		}
+
+		if update.Labels != nil {
+			if err := tx.Where("email_id = ?", id).Delete(&EmailLabel{}).Error; err != nil {
+				return err
+			}
+		}
+		if len(update.RemoveLabels) > 0 {
+			if err := tx.Where("email_id = ? AND name IN ?", id, update.RemoveLabels).Delete(&EmailLabel{}).Error; err != nil {
+				return err
+			}
+		}
+
+		var add []string
+		if update.Labels != nil {
+			add = append(add, *update.Labels...)
+		}
+		add = append(add, update.AddLabels...)
+		for _, name := range add {
+			label := EmailLabel{EmailID: id, Name: name}
+			// Adding a label the email already has is not an error
+			if err := tx.Where(label).FirstOrCreate(&label).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteEmail deletes an email of an account with its headers, attachments and raw message
+func (db *DB) DeleteEmail(id int64, accountID string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		report, err := deleteEmails(tx, "id = ? AND account_id = ?", id, accountID)
+		if err != nil {
+			return err
+		}
+		if report.Emails == 0 {
+			return ErrEmailNotFound
+		}
+		return nil
+	})
+}
+
+// DeleteEmails deletes the emails of an account matching the filter, returning how many were deleted
+func (db *DB) DeleteEmails(accountID string, filter EmailFilter) (int64, error) {
+	var report PurgeReport
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query, args := filter.conditions(tx, accountID)
+
+		var err error
+		report, err = deleteEmails(tx, query, args...)
+		return err
+	})
+	return report.Emails, err
+}
+
+// DeleteAccount deletes an account with all its emails
+func (db *DB) DeleteAccount(id string) (PurgeReport, error) {
+	report, err := db.purgeAccounts(func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("id = ?", id)
+	})
+	if err == nil && report.Accounts == 0 {
+		return report, ErrAccountNotFound
+	}
+	return report, err
+}
+
+// ExtendAccount sets a new expiry on an account that has not expired
+func (db *DB) ExtendAccount(id string, expiresAt time.Time) error {
+	if _, err := db.GetAccount(id); err != nil {
+		return err
+	}
+	// No rows are affected when the expiry does not change, MySQL counts changed rows rather than matched ones
+	return db.Model(&Account{}).Where("id = ?", id).Update("expires_at", expiresAt).Error
+}
+
+// Usage is the number and total size of the emails of an account
+type Usage struct {
+	Messages int64 `json:"messages"`
+	Unread   int64 `json:"unread"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// GetUsage returns how much an account currently stores
+func (db *DB) GetUsage(accountID string) (*Usage, error) {
+	var usage Usage
+	err := db.Model(&Email{}).
+		Select("COUNT(*) AS messages, COALESCE(SUM(CASE WHEN is_read THEN 0 ELSE 1 END), 0) AS unread, COALESCE(SUM(size), 0) AS bytes").
+		Where("account_id = ?", accountID).
+		Scan(&usage).Error
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// EvictEmails deletes the oldest emails of an account so that one more email of the given size fits
+// within maxMessages and maxBytes, a limit of zero or less is not enforced. It returns the number deleted.
+func (db *DB) EvictEmails(accountID string, maxMessages, maxBytes, size int64) (int, error) {
+	var emails []Email
+	err := db.Select("id", "size").
+		Where("account_id = ?", accountID).
+		Order("received_at, id").
+		Find(&emails).Error
+	if err != nil {
+		return 0, err
+	}
+
+	// The incoming email counts as stored, the oldest emails go until everything fits
+	count, bytes := int64(len(emails))+1, size
+	for _, email := range emails {
+		bytes += email.Size
+	}
+
+	var evicted []int64
+	for _, email := range emails {
+		if (maxMessages <= 0 || count <= maxMessages) && (maxBytes <= 0 || bytes <= maxBytes) {
+			break
+		}
+		evicted = append(evicted, email.ID)
+		count--
+		bytes -= email.Size
+	}
+
+	if len(evicted) == 0 {
+		return 0, nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		_, err := deleteEmails(tx, "id IN ?", evicted)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(evicted), nil
+}
+
+// PurgeReport counts the rows deleted by a retention run
+type PurgeReport struct {
+	Accounts    int64
+	Emails      int64
+	Headers     int64
+	Attachments int64
+	RawMessages int64
+	Labels      int64
+	Extractions int64
+}
+
+// Add accumulates the counts of another report
+func (r *PurgeReport) Add(other PurgeReport) {
+	r.Accounts += other.Accounts
+	r.Emails += other.Emails
+	r.Headers += other.Headers
+	r.Attachments += other.Attachments
+	r.RawMessages += other.RawMessages
+	r.Labels += other.Labels
+	r.Extractions += other.Extractions
+}
+
+// Total is the number of rows deleted
+func (r PurgeReport) Total() int64 {
+	return r.Accounts + r.Emails + r.Headers + r.Attachments + r.RawMessages + r.Labels + r.Extractions
+}
+
+// emailChildren are the tables holding rows that belong to an email, with their report counter
+var emailChildren = []struct {
+	model interface{}
+	count func(*PurgeReport) *int64
+}{
+	{&EmailHeader{}, func(r *PurgeReport) *int64 { return &r.Headers }},
+	{&Attachment{}, func(r *PurgeReport) *int64 { return &r.Attachments }},
+	{&RawMessage{}, func(r *PurgeReport) *int64 { return &r.RawMessages }},
+	{&EmailLabel{}, func(r *PurgeReport) *int64 { return &r.Labels }},
+	{&Extraction{}, func(r *PurgeReport) *int64 { return &r.Extractions }},
+}
+
+// deleteBatchSize bounds the number of email IDs deleted per statement
+const deleteBatchSize = 500
+
+// deleteEmails deletes the emails matching the condition together with their child rows,
+// which not every driver removes through foreign key cascades (SQLite does not by default).
+// The IDs are resolved first as the condition may depend on the child rows being deleted.
+func deleteEmails(tx *gorm.DB, query string, args ...interface{}) (PurgeReport, error) {
+	var report PurgeReport
+
+	var ids []int64
+	if err := tx.Model(&Email{}).Where(query, args...).Pluck("id", &ids).Error; err != nil {
+		return report, err
+	}
+
+	for batch := range slices.Chunk(ids, deleteBatchSize) {
+		for _, child := range emailChildren {
+			result := tx.Where("email_id IN ?", batch).Delete(child.model)
+			if result.Error != nil {
+				return report, result.Error
+			}
+			*child.count(&report) += result.RowsAffected
+		}
+
+		result := tx.Where("id IN ?", batch).Delete(&Email{})
+		if result.Error != nil {
+			return report, result.Error
+		}
+		report.Emails += result.RowsAffected
+	}
+
+	return report, nil
+}
+
+// AccountExists checks if an account exists and has not expired
 func (db *DB) AccountExists(id string) (bool, error) {
 	var count int64
-	if err := db.Model(&Account{}).Where("id = ?", id).Count(&count).Error; err != nil {
+	if err := db.Model(&Account{}).Scopes(unexpired).Where("id = ?", id).Count(&count).Error; err != nil {
 		return false, err
 	}
 	return count > 0, nil
 }
 
-// Cleanup deletes emails older than given interval
-func (db *DB) Cleanup(hours int) error {
-	cutoff := time.Now().Add(-time.Duration(hours) * time.Hour)
-	return db.Where("created_at < ?", cutoff).Delete(&Account{}).Error
+// PurgeAccounts deletes accounts without an expiry created before the cutoff with all their emails,
+// limited to accounts of the given domains if any
+func (db *DB) PurgeAccounts(cutoff time.Time, domains ...string) (PurgeReport, error) {
+	return db.purgeAccounts(func(tx *gorm.DB) *gorm.DB {
+		tx = tx.Where("expires_at IS NULL AND created_at < ?", cutoff)
+		if len(domains) > 0 {
+			tx = tx.Where("domain IN ?", domains)
+		}
+		return tx
+	})
+}
+
+// PurgeExpiredAccounts deletes accounts that expired before now with all their emails
+func (db *DB) PurgeExpiredAccounts(now time.Time) (PurgeReport, error) {
+	return db.purgeAccounts(func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("expires_at < ?", now)
+	})
+}
+
+// purgeAccounts deletes the accounts selected by scope with all their emails
+func (db *DB) purgeAccounts(scope func(*gorm.DB) *gorm.DB) (PurgeReport, error) {
+	var report PurgeReport
+	err := db.Transaction(func(tx *gorm.DB) error {
+		accounts := scope(tx.Model(&Account{}).Select("id"))
+
+		var err error
+		if report, err = deleteEmails(tx, "account_id IN (?)", accounts); err != nil {
+			return err
+		}
+
+		result := scope(tx).Delete(&Account{})
+		report.Accounts = result.RowsAffected
+		return result.Error
+	})
+	return report, err
+}
+
+// PurgeEmails deletes emails received before the cutoff, limited to accounts of the given domains if any
+func (db *DB) PurgeEmails(cutoff time.Time, domains ...string) (PurgeReport, error) {
+	var report PurgeReport
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if len(domains) > 0 {
+			accounts := tx.Model(&Account{}).Select("id").Where("domain IN ?", domains)
+			report, err = deleteEmails(tx, "received_at < ? AND account_id IN (?)", cutoff, accounts)
+		} else {
+			report, err = deleteEmails(tx, "received_at < ?", cutoff)
+		}
+		return err
+	})
+	return report, err
+}
+
+// PurgeOrphans deletes emails whose account is gone and child rows whose email is gone
+func (db *DB) PurgeOrphans() (PurgeReport, error) {
+	var report PurgeReport
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if report, err = deleteEmails(tx, "account_id NOT IN (?)", tx.Model(&Account{}).Select("id")); err != nil {
+			return err
+		}
+
+		emails := tx.Model(&Email{}).Select("id")
+		for _, child := range emailChildren {
+			result := tx.Where("email_id NOT IN (?)", emails).Delete(child.model)
+			if result.Error != nil {
+				return result.Error
+			}
+			*child.count(&report) += result.RowsAffected
+		}
+		return nil
+	})
+	return report, err
 }
 
 // Close closes the database (not needed with GORM unless using raw SQL DB)
@@ -0,0 +1,383 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/galihrivanto/kotak/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	db, err := New(config.Database{Driver: "sqlite", Database: filepath.Join(t.TempDir(), "kotak")})
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func storeTestEmail(t *testing.T, db *DB, accountID string, receivedAt time.Time) *Email {
+	t.Helper()
+
+	email := &Email{
+		AccountID:   accountID,
+		Subject:     "test",
+		Headers:     []EmailHeader{{Name: "Subject", Value: "test"}},
+		Attachments: []Attachment{{Filename: "a.txt", Data: []byte("a")}},
+		Raw:         &RawMessage{Data: []byte("Subject: test\r\n\r\n")},
+		ReceivedAt:  receivedAt,
+	}
+	_, err := db.StoreEmail(email)
+	require.NoError(t, err)
+	return email
+}
+
+func TestPurge(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+
+	require.NoError(t, db.CreateAccount(&Account{ID: "old", Domain: "a.test", CreatedAt: now.Add(-2 * time.Hour)}))
+	require.NoError(t, db.CreateAccount(&Account{ID: "new", Domain: "a.test", CreatedAt: now}))
+	storeTestEmail(t, db, "old", now)
+	storeTestEmail(t, db, "new", now.Add(-45*time.Minute))
+	kept := storeTestEmail(t, db, "new", now)
+
+	report, err := db.PurgeAccounts(now.Add(-time.Hour), "a.test")
+	require.NoError(t, err)
+	assert.Equal(t, PurgeReport{Accounts: 1, Emails: 1, Headers: 1, Attachments: 1, RawMessages: 1}, report)
+
+	// Sub-hour email retention
+	report, err = db.PurgeEmails(now.Add(-30*time.Minute), "a.test")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), report.Emails)
+	assert.Equal(t, int64(4), report.Total())
+
+	emails, err := db.GetEmails("new", EmailFilter{})
+	require.NoError(t, err)
+	require.Len(t, emails, 1)
+	assert.Equal(t, kept.ID, emails[0].ID)
+
+	// Accounts with an expiry are purged once it passes, regardless of their age
+	expired, later := now.Add(-time.Minute), now.Add(time.Minute)
+	require.NoError(t, db.CreateAccount(&Account{ID: "expired", Domain: "a.test", ExpiresAt: &expired}))
+	require.NoError(t, db.CreateAccount(&Account{ID: "extended", Domain: "a.test", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: &later}))
+	storeTestEmail(t, db, "expired", now)
+
+	report, err = db.PurgeAccounts(now.Add(-time.Hour), "a.test")
+	require.NoError(t, err)
+	assert.Zero(t, report.Accounts)
+
+	report, err = db.PurgeExpiredAccounts(now)
+	require.NoError(t, err)
+	assert.Equal(t, PurgeReport{Accounts: 1, Emails: 1, Headers: 1, Attachments: 1, RawMessages: 1}, report)
+
+	// Rows left behind by a delete that did not cascade
+	require.NoError(t, db.Delete(&Account{ID: "new"}).Error)
+	report, err = db.PurgeOrphans()
+	require.NoError(t, err)
+	assert.Equal(t, PurgeReport{Emails: 1, Headers: 1, Attachments: 1, RawMessages: 1}, report)
+}
+
+func TestExpiredAccounts(t *testing.T) {
+	db := newTestDB(t)
+	expired := time.Now().Add(-time.Minute)
+	require.NoError(t, db.CreateAccount(&Account{ID: "gone", Domain: "a.test", ExpiresAt: &expired}))
+	storeTestEmail(t, db, "gone", time.Now())
+
+	_, err := db.GetAccount("gone")
+	assert.ErrorIs(t, err, ErrAccountNotFound)
+	exists, err := db.AccountExists("gone")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// The ID is free again, without the emails of the expired account
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, db.CreateAccount(&Account{ID: "gone", Domain: "b.test", ExpiresAt: &later}))
+	assert.ErrorIs(t, db.CreateAccount(&Account{ID: "gone", Domain: "a.test"}), ErrAccountExists)
+
+	account, err := db.GetAccount("gone")
+	require.NoError(t, err)
+	assert.Equal(t, "b.test", account.Domain)
+
+	// Extending to the current expiry changes nothing and still succeeds
+	require.NoError(t, db.ExtendAccount("gone", *account.ExpiresAt))
+	assert.ErrorIs(t, db.ExtendAccount("missing", later), ErrAccountNotFound)
+	emails, err := db.GetEmails("gone", EmailFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, emails)
+}
+
+func TestUpdateEmail(t *testing.T) {
+	db := newTestDB(t)
+	require.NoError(t, db.CreateAccount(&Account{ID: "a"}))
+	first := storeTestEmail(t, db, "a", time.Now())
+	storeTestEmail(t, db, "a", time.Now())
+
+	read, starred := true, true
+	labels := []string{"work", "otp"}
+	require.NoError(t, db.UpdateEmail(first.ID, "a", EmailUpdate{Read: &read, Starred: &starred, Labels: &labels}))
+	require.NoError(t, db.UpdateEmail(first.ID, "a", EmailUpdate{AddLabels: []string{"otp", "later"}, RemoveLabels: []string{"work"}}))
+	assert.ErrorIs(t, db.UpdateEmail(first.ID, "b", EmailUpdate{Read: &read}), ErrEmailNotFound)
+
+	email, err := db.GetEmail(first.ID, "a")
+	require.NoError(t, err)
+	assert.True(t, email.Read)
+	assert.True(t, email.Starred)
+	require.Len(t, email.Labels, 2)
+	assert.Equal(t, "later", email.Labels[0].Name)
+	assert.Equal(t, "otp", email.Labels[1].Name)
+
+	unread := false
+	emails, err := db.GetEmails("a", EmailFilter{Read: &unread})
+	require.NoError(t, err)
+	assert.Len(t, emails, 1)
+
+	emails, err = db.GetEmails("a", EmailFilter{Label: "otp"})
+	require.NoError(t, err)
+	require.Len(t, emails, 1)
+	assert.Equal(t, first.ID, emails[0].ID)
+
+	usage, err := db.GetUsage("a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), usage.Messages)
+	assert.Equal(t, int64(1), usage.Unread)
+}
+
+func TestEvictEmails(t *testing.T) {
+	db := newTestDB(t)
+	require.NoError(t, db.CreateAccount(&Account{ID: "a"}))
+
+	now := time.Now()
+	var emails []*Email
+	for i, size := range []int64{10, 10, 100, 10} {
+		email := &Email{AccountID: "a", Size: size, ReceivedAt: now.Add(time.Duration(i) * time.Minute)}
+		_, err := db.StoreEmail(email)
+		require.NoError(t, err)
+		emails = append(emails, email)
+	}
+
+	// Making room for 50 bytes evicts the oldest emails first
+	evicted, err := db.EvictEmails("a", 0, 170, 50)
+	require.NoError(t, err)
+	assert.Equal(t, 1, evicted)
+
+	// The large email is evicted with the older one, rather than skipped for the newer one
+	evicted, err = db.EvictEmails("a", 0, 100, 50)
+	require.NoError(t, err)
+	assert.Equal(t, 2, evicted)
+
+	remaining, err := db.GetEmails("a", EmailFilter{})
+	require.NoError(t, err)
+	require.Len(t, remaining, 1)
+	assert.Equal(t, emails[3].ID, remaining[0].ID)
+
+	evicted, err = db.EvictEmails("a", 2, 0, 50)
+	require.NoError(t, err)
+	assert.Zero(t, evicted)
+}
+
+func TestListEmails(t *testing.T) {
+	db := newTestDB(t)
+	require.NoError(t, db.CreateAccount(&Account{ID: "a"}))
+
+	now := time.Now()
+	var ids []int64
+	for i := range 5 {
+		email := storeTestEmail(t, db, "a", now.Add(time.Duration(i)*time.Minute))
+		ids = append(ids, email.ID)
+	}
+
+	list, err := db.ListEmails("a", EmailFilter{}, EmailPage{Limit: 2})
+	require.NoError(t, err)
+	assert.True(t, list.HasMore)
+	require.Len(t, list.Emails, 2)
+	assert.Equal(t, ids[4], list.Emails[0].ID)
+	assert.True(t, list.Emails[0].HasAttachments)
+
+	list, err = db.ListEmails("a", EmailFilter{}, EmailPage{Limit: 2, After: list.Emails[1].ID})
+	require.NoError(t, err)
+	assert.True(t, list.HasMore)
+	assert.Equal(t, []int64{ids[2], ids[1]}, summaryIDs(list.Emails))
+
+	list, err = db.ListEmails("a", EmailFilter{}, EmailPage{Limit: 2, After: ids[1]})
+	require.NoError(t, err)
+	assert.False(t, list.HasMore)
+	assert.Equal(t, []int64{ids[0]}, summaryIDs(list.Emails))
+
+	// Paging backwards keeps the listing order
+	list, err = db.ListEmails("a", EmailFilter{}, EmailPage{Limit: 2, Before: ids[1]})
+	require.NoError(t, err)
+	assert.True(t, list.HasMore)
+	assert.Equal(t, []int64{ids[3], ids[2]}, summaryIDs(list.Emails))
+
+	list, err = db.ListEmails("a", EmailFilter{}, EmailPage{Limit: 10, Sort: SortFrom, Ascending: true, After: ids[2]})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{ids[3], ids[4]}, summaryIDs(list.Emails))
+
+	_, err = db.ListEmails("a", EmailFilter{}, EmailPage{Sort: "text"})
+	assert.Error(t, err)
+
+	// Cursors of other accounts are unknown
+	require.NoError(t, db.CreateAccount(&Account{ID: "b"}))
+	other := storeTestEmail(t, db, "b", now)
+	_, err = db.ListEmails("a", EmailFilter{}, EmailPage{Limit: 2, After: other.ID})
+	assert.ErrorIs(t, err, ErrCursorNotFound)
+	_, err = db.ListEmails("a", EmailFilter{}, EmailPage{Limit: 2, Before: other.ID + 1})
+	assert.ErrorIs(t, err, ErrCursorNotFound)
+
+	list, err = db.ListEmails("a", EmailFilter{AfterID: ids[3]}, EmailPage{})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{ids[4]}, summaryIDs(list.Emails))
+}
+
+func TestBackfillText(t *testing.T) {
+	db := newTestDB(t)
+	require.NoError(t, db.CreateAccount(&Account{ID: "a"}))
+
+	// Emails stored before snippets and HTML text were derived at ingest
+	require.NoError(t, db.Create(&Email{AccountID: "a", HTML: "<p>Hello <b>world</b></p>"}).Error)
+
+	require.NoError(t, backfillText(db.DB))
+	list, err := db.ListEmails("a", EmailFilter{}, EmailPage{})
+	require.NoError(t, err)
+	require.Len(t, list.Emails, 1)
+	assert.Equal(t, "Hello world", list.Emails[0].Snippet)
+
+	list, err = db.ListEmails("a", EmailFilter{Query: "world"}, EmailPage{})
+	require.NoError(t, err)
+	assert.Len(t, list.Emails, 1)
+}
+
+func summaryIDs(emails []EmailSummary) []int64 {
+	var ids []int64
+	for _, email := range emails {
+		ids = append(ids, email.ID)
+	}
+	return ids
+}
+
+func TestSearchEmails(t *testing.T) {
+	db := newTestDB(t)
+	require.NoError(t, db.CreateAccount(&Account{ID: "a"}))
+
+	now := time.Now()
+	reset := &Email{
+		AccountID:  "a",
+		From:       "Security <no-reply@shop.example>",
+		Subject:    "Reset your password",
+		HTML:       `<p>Click the <a href="https://tracker.example/r">link</a> to reset your password</p>`,
+		Headers:    []EmailHeader{{Name: "X-Mailer", Value: "ShopMailer 2.0"}},
+		ReceivedAt: now.Add(-10 * time.Minute),
+	}
+	_, err := db.StoreEmail(reset)
+	require.NoError(t, err)
+
+	newsletter := &Email{
+		AccountID:   "a",
+		From:        "news@shop.example",
+		Subject:     "Weekly 100% deals",
+		Text:        "Deals on passwords managers",
+		Attachments: []Attachment{{Filename: "deals.pdf", Data: []byte("%PDF")}},
+		ReceivedAt:  now,
+	}
+	_, err = db.StoreEmail(newsletter)
+	require.NoError(t, err)
+
+	yes, no := true, false
+	since := now.Add(-15 * time.Minute)
+	until := now.Add(-time.Minute)
+	testCases := []struct {
+		name   string
+		filter EmailFilter
+		want   []int64
+	}{
+		{"full text", EmailFilter{Query: "reset password"}, []int64{reset.ID}},
+		{"full text all words", EmailFilter{Query: "reset deals"}, nil},
+		{"full text quotes", EmailFilter{Query: `"reset`}, []int64{reset.ID}},
+		{"full text skips markup", EmailFilter{Query: "tracker"}, nil},
+		{"from", EmailFilter{From: "NO-REPLY"}, []int64{reset.ID}},
+		{"to", EmailFilter{To: "nobody"}, nil},
+		{"subject wildcard is literal", EmailFilter{Subject: "100%"}, []int64{newsletter.ID}},
+		{"date range", EmailFilter{Since: &since, Until: &until}, []int64{reset.ID}},
+		{"has attachments", EmailFilter{HasAttachments: &yes}, []int64{newsletter.ID}},
+		{"no attachments", EmailFilter{HasAttachments: &no}, []int64{reset.ID}},
+		{"header", EmailFilter{Headers: []HeaderFilter{{Name: "x-mailer", Value: "shopmailer"}}}, []int64{reset.ID}},
+		{"header name", EmailFilter{Headers: []HeaderFilter{{Name: "X-Campaign"}}}, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			list, err := db.ListEmails("a", tc.filter, EmailPage{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, summaryIDs(list.Emails))
+		})
+	}
+
+	// Deleting by a filter on child rows removes the matching emails and their search entries
+	deleted, err := db.DeleteEmails("a", EmailFilter{Headers: []HeaderFilter{{Name: "X-Mailer"}}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	var indexed int64
+	require.NoError(t, db.Raw("SELECT COUNT(*) FROM emails_search WHERE emails_search MATCH 'reset'").Scan(&indexed).Error)
+	assert.Zero(t, indexed)
+}
+
+func TestLatestExtraction(t *testing.T) {
+	db := newTestDB(t)
+	require.NoError(t, db.CreateAccount(&Account{ID: "a"}))
+
+	older := &Email{AccountID: "a", Tag: "signup", Extractions: []Extraction{{Kind: "code", Value: "1111"}}}
+	newer := &Email{AccountID: "a", Extractions: []Extraction{
+		{Kind: "verification", Value: "https://example.com/verify", Text: "Verify"},
+		{Kind: "code", Value: "2222"},
+		{Kind: "code", Value: "3333"},
+	}}
+	for _, email := range []*Email{older, newer} {
+		_, err := db.StoreEmail(email)
+		require.NoError(t, err)
+	}
+
+	code, err := db.LatestExtraction("a", "code", EmailFilter{})
+	require.NoError(t, err)
+	require.NotNil(t, code)
+	assert.Equal(t, "2222", code.Value)
+	assert.Equal(t, newer.ID, code.EmailID)
+
+	code, err = db.LatestExtraction("a", "code", EmailFilter{Tag: "signup"})
+	require.NoError(t, err)
+	require.NotNil(t, code)
+	assert.Equal(t, "1111", code.Value)
+
+	code, err = db.LatestExtraction("b", "code", EmailFilter{})
+	require.NoError(t, err)
+	assert.Nil(t, code)
+
+	extractions, err := db.GetExtractions(newer.ID, "a")
+	require.NoError(t, err)
+	assert.Len(t, extractions, 3)
+
+	_, err = db.GetExtractions(newer.ID, "b")
+	assert.ErrorIs(t, err, ErrEmailNotFound)
+
+	report, err := db.DeleteAccount("a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), report.Extractions)
+}
+
+func TestSearchConditionMySQL(t *testing.T) {
+	tx := &gorm.DB{Config: &gorm.Config{Dialector: mysql.New(mysql.Config{})}}
+
+	// Short words and stopwords are not indexed by MySQL, requiring them would match nothing
+	cond, args := searchCondition(tx, `the "reset" of my password`)
+	assert.Equal(t, "MATCH (subject, text, html_text) AGAINST (? IN BOOLEAN MODE)", cond)
+	assert.Equal(t, []interface{}{`+"reset" +"password"`}, args)
+
+	cond, _ = searchCondition(tx, "to be")
+	assert.Equal(t, "1 = 0", cond)
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// Full-text search covers the subject, the plain text body and the visible text of the HTML
+// body of an email, markup left out. Each driver keeps its own index: an FTS5 table maintained
+// by triggers on SQLite, a GIN index over a tsvector expression on Postgres and a FULLTEXT index
+// on MySQL.
+//
+// The drivers tokenize differently. MySQL ignores stopwords and words shorter than
+// innodb_ft_min_token_size, so those words are left out of MySQL queries rather than making
+// them match nothing. SQLite and Postgres index every word.
+const (
+	searchTable = "emails_search"
+	searchIndex = "idx_emails_search"
+
+	// postgresDocument is the indexed expression, queries must repeat it verbatim to use the index
+	postgresDocument = "to_tsvector('simple', coalesce(subject, '') || ' ' || coalesce(text, '') || ' ' || coalesce(html_text, ''))"
+
+	// mysqlMinTokenSize is the default innodb_ft_min_token_size
+	mysqlMinTokenSize = 3
+)
+
+// mysqlStopwords is the default InnoDB full-text stopword list
+var mysqlStopwords = map[string]bool{
+	"a": true, "about": true, "an": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"com": true, "de": true, "en": true, "for": true, "from": true, "how": true, "i": true, "in": true,
+	"is": true, "it": true, "la": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"this": true, "to": true, "was": true, "what": true, "when": true, "where": true, "who": true,
+	"will": true, "with": true, "und": true, "www": true,
+}
+
+// migrateSearch creates the full-text index of emails when missing
+func migrateSearch(db *gorm.DB) error {
+	switch db.Dialector.Name() {
+	case "sqlite":
+		var count int64
+		err := db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", searchTable).Scan(&count).Error
+		if err != nil || count > 0 {
+			return err
+		}
+
+		return db.Transaction(func(tx *gorm.DB) error {
+			statements := []string{
+				`CREATE VIRTUAL TABLE emails_search USING fts5(subject, text, html_text, content='emails', content_rowid='id')`,
+				`CREATE TRIGGER emails_search_insert AFTER INSERT ON emails BEGIN
+					INSERT INTO emails_search(rowid, subject, text, html_text) VALUES (new.id, new.subject, new.text, new.html_text);
+				END`,
+				`CREATE TRIGGER emails_search_delete AFTER DELETE ON emails BEGIN
+					INSERT INTO emails_search(emails_search, rowid, subject, text, html_text) VALUES ('delete', old.id, old.subject, old.text, old.html_text);
+				END`,
+				`CREATE TRIGGER emails_search_update AFTER UPDATE OF subject, text, html_text ON emails BEGIN
+					INSERT INTO emails_search(emails_search, rowid, subject, text, html_text) VALUES ('delete', old.id, old.subject, old.text, old.html_text);
+					INSERT INTO emails_search(rowid, subject, text, html_text) VALUES (new.id, new.subject, new.text, new.html_text);
+				END`,
+				// Index the emails stored before search existed
+				`INSERT INTO emails_search(emails_search) VALUES ('rebuild')`,
+			}
+			for _, statement := range statements {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case "postgres":
+		return db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON emails USING GIN (%s)", searchIndex, postgresDocument)).Error
+	case "mysql":
+		if db.Migrator().HasIndex(&Email{}, searchIndex) {
+			return nil
+		}
+		return db.Exec(fmt.Sprintf("ALTER TABLE emails ADD FULLTEXT INDEX %s (subject, text, html_text)", searchIndex)).Error
+	}
+	return nil
+}
+
+// searchCondition returns the condition matching emails containing all words of the query
+func searchCondition(tx *gorm.DB, query string) (string, []interface{}) {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return "", nil
+	}
+
+	switch tx.Dialector.Name() {
+	case "postgres":
+		return postgresDocument + " @@ plainto_tsquery('simple', ?)", []interface{}{strings.Join(words, " ")}
+	case "mysql":
+		// Every indexed word is required, quoted so boolean mode operators in it are taken literally
+		var required []string
+		for _, word := range words {
+			word = strings.ReplaceAll(word, `"`, "")
+			if utf8.RuneCountInString(word) < mysqlMinTokenSize || mysqlStopwords[strings.ToLower(word)] {
+				continue
+			}
+			required = append(required, `+"`+word+`"`)
+		}
+		if len(required) == 0 {
+			// Only words MySQL does not index, which match nothing
+			return "1 = 0", nil
+		}
+		return "MATCH (subject, text, html_text) AGAINST (? IN BOOLEAN MODE)", []interface{}{strings.Join(required, " ")}
+	default:
+		// FTS5 strings quote the words, with embedded quotes doubled
+		for i, word := range words {
+			words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+		}
+		return "id IN (SELECT rowid FROM emails_search WHERE emails_search MATCH ?)", []interface{}{strings.Join(words, " ")}
+	}
+}
+
+// containsPattern returns a LIKE pattern matching values containing s, escaped with '!'
+func containsPattern(s string) string {
+	s = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(strings.ToLower(s))
+	return "%" + s + "%"
+}
@@ -0,0 +1,103 @@
+package event
+
+import (
+	"sync"
+
+	"github.com/galihrivanto/kotak/db"
+)
+
+// Event types
+const (
+	EmailReceived = "email.received"
+)
+
+// subscriptionBuffer is the number of events queued for a slow subscriber before dropping
+const subscriptionBuffer = 16
+
+// Event is a notification about a change in an account
+type Event struct {
+	Type      string    `json:"type"`
+	AccountID string    `json:"account_id"`
+	Email     *db.Email `json:"email,omitempty"`
+}
+
+// Subscription receives the events of a single account
+type Subscription struct {
+	C <-chan Event
+
+	ch        chan Event
+	broker    *Broker
+	accountID string
+	once      sync.Once
+}
+
+// Close stops the subscription and closes its channel
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.broker.unsubscribe(s)
+	})
+}
+
+// Broker is an in-process publish/subscribe hub keyed by account
+type Broker struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[*Subscription]struct{}
+}
+
+// NewBroker creates an empty broker
+func NewBroker() *Broker {
+	return &Broker{subscribers: map[string]map[*Subscription]struct{}{}}
+}
+
+// Subscribe registers a subscription for events of the given account
+func (b *Broker) Subscribe(accountID string) *Subscription {
+	ch := make(chan Event, subscriptionBuffer)
+	sub := &Subscription{C: ch, ch: ch, broker: b, accountID: accountID}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscribers[accountID] == nil {
+		b.subscribers[accountID] = map[*Subscription]struct{}{}
+	}
+	b.subscribers[accountID][sub] = struct{}{}
+
+	return sub
+}
+
+// Publish delivers an event to every subscriber of its account without blocking.
+// Subscribers that are not keeping up miss the event.
+func (b *Broker) Publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subscribers[e.AccountID] {
+		select {
+		case sub.ch <- e:
+		default:
+		}
+	}
+}
+
+func (b *Broker) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers[sub.accountID], sub)
+	if len(b.subscribers[sub.accountID]) == 0 {
+		delete(b.subscribers, sub.accountID)
+	}
+	close(sub.ch)
+}
+
+var defaultBroker = NewBroker()
+
+// Subscribe registers a subscription on the default broker
+func Subscribe(accountID string) *Subscription {
+	return defaultBroker.Subscribe(accountID)
+}
+
+// Publish delivers an event through the default broker
+func Publish(e Event) {
+	defaultBroker.Publish(e)
+}
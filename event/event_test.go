@@ -0,0 +1,50 @@
+package event
+
+import (
+	"testing"
+
+	"github.com/galihrivanto/kotak/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBroker(t *testing.T) {
+	b := NewBroker()
+
+	alice := b.Subscribe("alice")
+	bob := b.Subscribe("bob")
+	defer bob.Close()
+
+	b.Publish(Event{Type: EmailReceived, AccountID: "alice", Email: &db.Email{ID: 1}})
+
+	select {
+	case e := <-alice.C:
+		assert.Equal(t, int64(1), e.Email.ID)
+	default:
+		t.Fatal("expected event for alice")
+	}
+
+	select {
+	case <-bob.C:
+		t.Fatal("unexpected event for bob")
+	default:
+	}
+
+	alice.Close()
+	_, ok := <-alice.C
+	assert.False(t, ok)
+
+	// Publishing to a closed subscription must not panic
+	b.Publish(Event{Type: EmailReceived, AccountID: "alice"})
+}
+
+func TestBrokerSlowSubscriber(t *testing.T) {
+	b := NewBroker()
+	sub := b.Subscribe("alice")
+	defer sub.Close()
+
+	for i := 0; i < subscriptionBuffer*2; i++ {
+		b.Publish(Event{Type: EmailReceived, AccountID: "alice"})
+	}
+
+	assert.Len(t, sub.C, subscriptionBuffer)
+}
@@ -0,0 +1,207 @@
+// Package extract finds one-time codes and actionable links in email content
+package extract
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Kind is what an extracted item is used for
+type Kind string
+
+const (
+	Code         Kind = "code"
+	Verification Kind = "verification"
+	Reset        Kind = "reset"
+	Unsubscribe  Kind = "unsubscribe"
+)
+
+// Item is a code or link found in an email
+type Item struct {
+	Kind  Kind
+	Value string
+	// Text is the anchor text of a link, when it has one
+	Text string
+}
+
+// Content is the part of an email searched for codes and links
+type Content struct {
+	Subject string
+	Text    string
+	HTML    string
+	// ListUnsubscribe is the List-Unsubscribe header value
+	ListUnsubscribe string
+}
+
+// codeWindow is how far before or after a code keyword a code is looked for, in bytes
+const codeWindow = 80
+
+var (
+	codeKeyword = regexp.MustCompile(`(?i)\b(code|otp|pin|passcode|one[- ]time|verification|verify|token|2fa|security)\b`)
+	// Codes are 4 to 8 digits, possibly grouped in two halves, or 6 to 8 letters and digits
+	codeCandidate = regexp.MustCompile(`\b(\d{3}[ -]\d{3}|\d{4,8}|[A-Z0-9]{6,8})\b`)
+	urlPattern    = regexp.MustCompile(`https?://[^\s<>"'()\[\]]+`)
+)
+
+// linkKinds classify links by their URL and anchor text, the first match wins
+var linkKinds = []struct {
+	kind    Kind
+	pattern *regexp.Regexp
+}{
+	{Unsubscribe, regexp.MustCompile(`(?i)unsubscribe|opt[- _]?out|email[- _]?preferences`)},
+	{Reset, regexp.MustCompile(`(?i)reset|forgot|recover`)},
+	{Verification, regexp.MustCompile(`(?i)verif|confirm|activat|validat|magic|log[- _]?in|sign[- _]?in|auth`)},
+}
+
+// Extract returns the codes and the verification, reset and unsubscribe links of an email,
+// codes in the order they appear and each value once per kind
+func Extract(content Content) []Item {
+	text, anchors := content.Text, []anchor(nil)
+	if content.HTML != "" {
+		var htmlText string
+		htmlText, anchors = parseHTML(content.HTML)
+		if strings.TrimSpace(text) == "" {
+			text = htmlText
+		}
+	}
+
+	var items []Item
+	seen := make(map[Item]bool)
+	add := func(item Item) {
+		key := Item{Kind: item.Kind, Value: item.Value}
+		if !seen[key] {
+			seen[key] = true
+			items = append(items, item)
+		}
+	}
+
+	// Query parameters of links are no codes
+	for _, code := range codes(content.Subject + "\n" + urlPattern.ReplaceAllString(text, " ")) {
+		add(Item{Kind: Code, Value: code})
+	}
+
+	for _, a := range anchors {
+		if kind, ok := classify(a.href + " " + a.text); ok {
+			add(Item{Kind: kind, Value: a.href, Text: a.text})
+		}
+	}
+	for _, url := range urlPattern.FindAllString(text, -1) {
+		url = strings.TrimRight(url, ".,;:!?")
+		if kind, ok := classify(url); ok {
+			add(Item{Kind: kind, Value: url})
+		}
+	}
+	for _, url := range urlPattern.FindAllString(content.ListUnsubscribe, -1) {
+		add(Item{Kind: Unsubscribe, Value: strings.TrimRight(url, ">,")})
+	}
+
+	return items
+}
+
+// codes returns the code candidates close to a code keyword
+func codes(text string) []string {
+	var found []string
+	for _, loc := range codeCandidate.FindAllStringIndex(text, -1) {
+		start, end := loc[0], loc[1]
+		candidate := text[start:end]
+
+		// Letter codes need digits too, so that plain upper case words are left out
+		if !strings.ContainsAny(candidate, "0123456789") {
+			continue
+		}
+		// Amounts, percentages, dates, times and phone numbers continue past the number
+		if start > 0 && strings.IndexByte("$#+-/.:,", text[start-1]) >= 0 {
+			continue
+		}
+		if end < len(text) && text[end] == '%' {
+			continue
+		}
+		if end+1 < len(text) && strings.IndexByte("/.:,-", text[end]) >= 0 && isDigit(text[end+1]) {
+			continue
+		}
+
+		before := text[max(0, start-codeWindow):start]
+		after := text[end:min(len(text), end+codeWindow)]
+		if codeKeyword.MatchString(before) || codeKeyword.MatchString(after) {
+			found = append(found, strings.NewReplacer(" ", "", "-", "").Replace(candidate))
+		}
+	}
+	return found
+}
+
+// isDigit reports whether c is an ASCII digit
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// classify returns the kind of a link from its URL and anchor text
+func classify(s string) (Kind, bool) {
+	for _, k := range linkKinds {
+		if k.pattern.MatchString(s) {
+			return k.kind, true
+		}
+	}
+	return "", false
+}
+
+// anchor is a link of an HTML document
+type anchor struct {
+	href string
+	text string
+}
+
+// parseHTML returns the visible text and the http(s) links of an HTML document
+func parseHTML(document string) (string, []anchor) {
+	var text strings.Builder
+	var anchors []anchor
+	var current *anchor
+	var skip atom.Atom
+
+	z := html.NewTokenizer(strings.NewReader(document))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return text.String(), anchors
+		case html.StartTagToken:
+			token := z.Token()
+			switch token.DataAtom {
+			// Only elements whose text is never shown are skipped, mail often leaves out </head>
+			case atom.Script, atom.Style, atom.Title:
+				if skip == 0 {
+					skip = token.DataAtom
+				}
+			case atom.A:
+				for _, attr := range token.Attr {
+					if attr.Key == "href" && urlPattern.MatchString(attr.Val) {
+						current = &anchor{href: strings.TrimSpace(attr.Val)}
+					}
+				}
+			case atom.Br, atom.P, atom.Div, atom.Tr, atom.Li:
+				text.WriteByte('\n')
+			}
+		case html.EndTagToken:
+			token := z.Token()
+			if token.DataAtom == skip {
+				skip = 0
+			}
+			if token.DataAtom == atom.A && current != nil {
+				current.text = strings.Join(strings.Fields(current.text), " ")
+				anchors = append(anchors, *current)
+				current = nil
+			}
+			text.WriteByte(' ')
+		case html.TextToken:
+			if skip != 0 {
+				continue
+			}
+			data := string(z.Text())
+			text.WriteString(data)
+			if current != nil {
+				current.text += data
+			}
+		}
+	}
+}
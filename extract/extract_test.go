@@ -0,0 +1,68 @@
+package extract
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractCodes(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content Content
+		want    []string
+	}{
+		{"digits", Content{Text: "Your verification code is 482913."}, []string{"482913"}},
+		{"subject", Content{Subject: "123456 is your login code"}, []string{"123456"}},
+		{"grouped", Content{Text: "Enter the code 482 913 to continue"}, []string{"482913"}},
+		{"letters and digits", Content{Text: "Your one-time passcode: X7K2QP"}, []string{"X7K2QP"}},
+		{"upper case words", Content{Text: "CODE: PLEASE IGNORE"}, nil},
+		{"no keyword", Content{Text: "Order 482913 has shipped"}, nil},
+		{"amounts and dates", Content{Text: "Security notice: you paid $1200 on 2024-05-01, 50% off, call +1234567"}, nil},
+		{"query parameters", Content{Text: "Verify at https://example.com/verify?token=AB12CD34"}, nil},
+		{"html", Content{HTML: "<style>.code{}</style><p>Your code</p><p><b>9081</b></p>"}, []string{"9081"}},
+		{"unclosed head", Content{HTML: "<html><head><title>Code 1111</title><style>p{}</style><body><p>Your code is 5309</p>"}, []string{"5309"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var codes []string
+			for _, item := range Extract(tc.content) {
+				if item.Kind == Code {
+					codes = append(codes, item.Value)
+				}
+			}
+			assert.Equal(t, tc.want, codes)
+		})
+	}
+}
+
+func TestExtractLinks(t *testing.T) {
+	items := Extract(Content{
+		Text: "Confirm your account: https://example.com/confirm/abc.\nNews: https://example.com/blog",
+		HTML: `<p><a href="https://example.com/r/1">Reset   password</a>
+			<a href="https://example.com/u?id=1">Unsubscribe</a>
+			<a href="mailto:help@example.com">Contact</a>
+			<a href="https://example.com/a/1">Sign in to Example</a></p>`,
+		ListUnsubscribe: "<mailto:u@example.com>, <https://example.com/list-unsub>",
+	})
+
+	assert.Equal(t, []Item{
+		{Kind: Reset, Value: "https://example.com/r/1", Text: "Reset password"},
+		{Kind: Unsubscribe, Value: "https://example.com/u?id=1", Text: "Unsubscribe"},
+		{Kind: Verification, Value: "https://example.com/a/1", Text: "Sign in to Example"},
+		{Kind: Verification, Value: "https://example.com/confirm/abc"},
+		{Kind: Unsubscribe, Value: "https://example.com/list-unsub"},
+	}, items)
+}
+
+func TestSnippet(t *testing.T) {
+	assert.Equal(t, "Hello world", Snippet("  Hello\r\n\r\n  world  ", "<p>ignored</p>"))
+	assert.Equal(t, "Your code is 1234", Snippet(" ", "<head><title>T</title></head><p>Your code</p><p>is <b>1234</b></p>"))
+	assert.Equal(t, "Hello", Snippet("", "<html><head><meta charset=utf-8><title>T</title><body><p>Hello</p>"))
+
+	snippet := []rune(Snippet(strings.Repeat("é", 200), ""))
+	assert.Len(t, snippet, snippetLength)
+	assert.Equal(t, '…', snippet[snippetLength-1])
+}
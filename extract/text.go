@@ -0,0 +1,37 @@
+package extract
+
+import "strings"
+
+// snippetLength is the maximum number of characters of a snippet
+const snippetLength = 160
+
+// HTMLText returns the visible text of an HTML document, without markup, scripts and styles
+func HTMLText(document string) string {
+	text, _ := parseHTML(document)
+	return text
+}
+
+// Snippet returns the start of an email body as a single line of text,
+// taken from the HTML body when there is no plain text one
+func Snippet(text, html string) string {
+	if strings.TrimSpace(text) == "" && html != "" {
+		text = HTMLText(html)
+	}
+
+	var b strings.Builder
+	for _, word := range strings.Fields(text) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(word)
+		if b.Len() >= snippetLength*4 {
+			break
+		}
+	}
+
+	runes := []rune(b.String())
+	if len(runes) > snippetLength {
+		return strings.TrimSpace(string(runes[:snippetLength-1])) + "…"
+	}
+	return string(runes)
+}
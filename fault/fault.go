@@ -0,0 +1,280 @@
+package fault
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Default reply for random failures when no code is configured
+const (
+	defaultFailureCode    = 451
+	defaultFailureMessage = "4.3.0 Temporary failure injected"
+)
+
+// Stages of the SMTP transaction rules apply at
+const (
+	// StageRcpt answers the RCPT TO command of a matching recipient
+	StageRcpt = "rcpt"
+	// StageData answers the end of DATA of a message with a matching recipient
+	StageData = "data"
+)
+
+// Rule answers matching recipients with a fixed reply, or drops the connection
+type Rule struct {
+	// Recipient is a regular expression matched against each recipient address
+	Recipient string `mapstructure:"recipient" yaml:"recipient" json:"recipient"`
+	Code      int    `mapstructure:"code" yaml:"code" json:"code,omitempty"`
+	Message   string `mapstructure:"message" yaml:"message" json:"message,omitempty"`
+	Drop      bool   `mapstructure:"drop" yaml:"drop" json:"drop,omitempty"`
+	// Stage is rcpt (default) or data
+	Stage string `mapstructure:"stage" yaml:"stage" json:"stage,omitempty"`
+
+	recipient *regexp.Regexp
+}
+
+// Settings is the fault injection setup of the SMTP server
+type Settings struct {
+	Enabled bool   `mapstructure:"enabled" yaml:"enabled" json:"enabled"`
+	Rules   []Rule `mapstructure:"rules" yaml:"rules" json:"rules"`
+
+	// FailureRate is the percentage of messages rejected with FailureCode
+	FailureRate    float64 `mapstructure:"failure_rate" yaml:"failure_rate" json:"failure_rate"`
+	FailureCode    int     `mapstructure:"failure_code" yaml:"failure_code" json:"failure_code,omitempty"`
+	FailureMessage string  `mapstructure:"failure_message" yaml:"failure_message" json:"failure_message,omitempty"`
+
+	// DropRate is the percentage of messages whose connection is closed instead of answered
+	DropRate float64 `mapstructure:"drop_rate" yaml:"drop_rate" json:"drop_rate"`
+
+	// Latency delays every reply of the server
+	Latency time.Duration `mapstructure:"latency" yaml:"latency" json:"latency"`
+}
+
+// settingsJSON is Settings with the latency as a duration string such as "1.5s"
+type settingsJSON struct {
+	Enabled        bool    `json:"enabled"`
+	Rules          []Rule  `json:"rules"`
+	FailureRate    float64 `json:"failure_rate"`
+	FailureCode    int     `json:"failure_code,omitempty"`
+	FailureMessage string  `json:"failure_message,omitempty"`
+	DropRate       float64 `json:"drop_rate"`
+	Latency        string  `json:"latency"`
+}
+
+func (s Settings) MarshalJSON() ([]byte, error) {
+	rules := s.Rules
+	if rules == nil {
+		rules = []Rule{}
+	}
+	return json.Marshal(settingsJSON{
+		Enabled:        s.Enabled,
+		Rules:          rules,
+		FailureRate:    s.FailureRate,
+		FailureCode:    s.FailureCode,
+		FailureMessage: s.FailureMessage,
+		DropRate:       s.DropRate,
+		Latency:        s.Latency.String(),
+	})
+}
+
+func (s *Settings) UnmarshalJSON(data []byte) error {
+	var raw settingsJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	var latency time.Duration
+	if raw.Latency != "" {
+		var err error
+		if latency, err = time.ParseDuration(raw.Latency); err != nil {
+			return fmt.Errorf("invalid latency: %w", err)
+		}
+	}
+
+	*s = Settings{
+		Enabled:        raw.Enabled,
+		Rules:          raw.Rules,
+		FailureRate:    raw.FailureRate,
+		FailureCode:    raw.FailureCode,
+		FailureMessage: raw.FailureMessage,
+		DropRate:       raw.DropRate,
+		Latency:        latency,
+	}
+	return nil
+}
+
+// Validate checks the settings and compiles the rule patterns
+func (s *Settings) Validate() error {
+	for i := range s.Rules {
+		rule := &s.Rules[i]
+		re, err := regexp.Compile(rule.Recipient)
+		if err != nil {
+			return fmt.Errorf("rule %d: invalid recipient pattern: %w", i, err)
+		}
+		rule.recipient = re
+
+		if !rule.Drop && !isErrorCode(rule.Code) {
+			return fmt.Errorf("rule %d: code must be between 400 and 599", i)
+		}
+		switch rule.Stage {
+		case "", StageRcpt, StageData:
+		default:
+			return fmt.Errorf("rule %d: stage must be rcpt or data", i)
+		}
+	}
+
+	if s.FailureRate < 0 || s.FailureRate > 100 || s.DropRate < 0 || s.DropRate > 100 {
+		return fmt.Errorf("rates must be percentages between 0 and 100")
+	}
+	if s.FailureCode != 0 && !isErrorCode(s.FailureCode) {
+		return fmt.Errorf("failure code must be between 400 and 599")
+	}
+	if s.Latency < 0 {
+		return fmt.Errorf("latency must not be negative")
+	}
+	return nil
+}
+
+func isErrorCode(code int) bool {
+	return code >= 400 && code <= 599
+}
+
+// Outcome is the injected result of a message delivery
+type Outcome struct {
+	// Drop closes the connection without a reply
+	Drop bool
+	// Reply is the SMTP reply line, e.g. "451 4.3.0 Try again later"
+	Reply string
+}
+
+// Injector decides which faults to inject, its settings can be replaced at any time
+type Injector struct {
+	mu       sync.RWMutex
+	settings Settings
+}
+
+// NewInjector creates an injector with fault injection disabled
+func NewInjector() *Injector {
+	return &Injector{}
+}
+
+// Settings returns the current settings
+func (i *Injector) Settings() Settings {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.settings
+}
+
+// Configure validates and applies new settings
+func (i *Injector) Configure(settings Settings) error {
+	settings.Rules = append([]Rule(nil), settings.Rules...)
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.settings = settings
+	return nil
+}
+
+// Latency returns the delay to add before each reply
+func (i *Injector) Latency() time.Duration {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if !i.settings.Enabled {
+		return 0
+	}
+	return i.settings.Latency
+}
+
+// Recipient decides the outcome of the RCPT TO command of a recipient, nil when it is accepted normally.
+// The first rcpt stage rule matching the recipient wins.
+func (i *Injector) Recipient(recipient string) *Outcome {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if !i.settings.Enabled {
+		return nil
+	}
+	return i.settings.match(StageRcpt, []string{recipient})
+}
+
+// Deliver decides the outcome of a message to the given recipients, nil when it is delivered normally.
+// The first data stage rule matching any recipient wins, otherwise the drop and failure rates apply.
+func (i *Injector) Deliver(recipients []string) *Outcome {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	s := i.settings
+	if !s.Enabled {
+		return nil
+	}
+
+	if outcome := s.match(StageData, recipients); outcome != nil {
+		return outcome
+	}
+
+	if s.DropRate > 0 && rand.Float64()*100 < s.DropRate {
+		return &Outcome{Drop: true}
+	}
+	if s.FailureRate > 0 && rand.Float64()*100 < s.FailureRate {
+		code, message := s.FailureCode, s.FailureMessage
+		if code == 0 {
+			code = defaultFailureCode
+		}
+		if message == "" && code == defaultFailureCode {
+			message = defaultFailureMessage
+		}
+		return &Outcome{Reply: reply(code, message)}
+	}
+	return nil
+}
+
+// match returns the outcome of the first rule of the stage matching any recipient
+func (s *Settings) match(stage string, recipients []string) *Outcome {
+	for _, rule := range s.Rules {
+		if rule.stage() != stage {
+			continue
+		}
+		for _, recipient := range recipients {
+			if rule.recipient == nil || !rule.recipient.MatchString(recipient) {
+				continue
+			}
+			if rule.Drop {
+				return &Outcome{Drop: true}
+			}
+			return &Outcome{Reply: reply(rule.Code, rule.Message)}
+		}
+	}
+	return nil
+}
+
+func (r Rule) stage() string {
+	if r.Stage == "" {
+		return StageRcpt
+	}
+	return r.Stage
+}
+
+// reply formats an SMTP reply line with a default text for the code class
+func reply(code int, message string) string {
+	if message == "" {
+		if code < 500 {
+			message = "4.0.0 Temporary failure injected"
+		} else {
+			message = "5.0.0 Permanent failure injected"
+		}
+	}
+	return fmt.Sprintf("%d %s", code, message)
+}
+
+var defaultInjector = NewInjector()
+
+// Default returns the injector shared by the SMTP server and the admin API
+func Default() *Injector {
+	return defaultInjector
+}
@@ -0,0 +1,57 @@
+package fault
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInjectorDeliver(t *testing.T) {
+	injector := NewInjector()
+	assert.Nil(t, injector.Deliver([]string{"a@example.com"}))
+
+	err := injector.Configure(Settings{
+		Enabled: true,
+		Rules: []Rule{
+			{Recipient: `^retry@`, Code: 451, Stage: StageData},
+			{Recipient: `^drop@`, Drop: true, Stage: StageData},
+			{Recipient: `^bounce@`, Code: 550, Message: "5.1.1 No such user", Stage: StageData},
+			{Recipient: `^greylist@`, Code: 450},
+		},
+		Latency: time.Second,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, &Outcome{Reply: "451 4.0.0 Temporary failure injected"}, injector.Deliver([]string{"ok@example.com", "retry@example.com"}))
+	assert.Equal(t, &Outcome{Drop: true}, injector.Deliver([]string{"drop@example.com"}))
+	assert.Equal(t, &Outcome{Reply: "550 5.1.1 No such user"}, injector.Deliver([]string{"bounce@example.com"}))
+	assert.Nil(t, injector.Deliver([]string{"ok@example.com"}))
+
+	// Rules apply at their own stage only
+	assert.Nil(t, injector.Deliver([]string{"greylist@example.com"}))
+	assert.Equal(t, &Outcome{Reply: "450 4.0.0 Temporary failure injected"}, injector.Recipient("greylist@example.com"))
+	assert.Nil(t, injector.Recipient("retry@example.com"))
+	assert.Equal(t, time.Second, injector.Latency())
+
+	require.NoError(t, injector.Configure(Settings{Enabled: true, FailureRate: 100}))
+	assert.Equal(t, &Outcome{Reply: "451 4.3.0 Temporary failure injected"}, injector.Deliver([]string{"ok@example.com"}))
+
+	assert.Error(t, injector.Configure(Settings{Rules: []Rule{{Recipient: "(", Code: 451}}}))
+	assert.Error(t, injector.Configure(Settings{Rules: []Rule{{Recipient: ".", Code: 250}}}))
+	assert.Error(t, injector.Configure(Settings{FailureRate: 101}))
+	assert.Error(t, injector.Configure(Settings{Rules: []Rule{{Recipient: ".", Code: 451, Stage: "mail"}}}))
+}
+
+func TestSettingsJSON(t *testing.T) {
+	var s Settings
+	require.NoError(t, json.Unmarshal([]byte(`{"enabled":true,"latency":"250ms","rules":[{"recipient":"x","code":421}]}`), &s))
+	assert.Equal(t, 250*time.Millisecond, s.Latency)
+	assert.Len(t, s.Rules, 1)
+
+	data, err := json.Marshal(s)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"latency":"250ms"`)
+}
@@ -3,18 +3,18 @@ import { emailService } from './services/api';
 import AccountSection from './components/AccountSection';
 import InboxSection from './components/InboxSection';
 import EmailDetailSection from './components/EmailDetailSection';
-import { Account, Email } from './types';
+import { Account, EmailSummary } from './types';
 import './styles.css';
 
 const App: React.FC = () => {
     const [currentAccount, setCurrentAccount] = useState<Account | null>(null);
-    const [currentEmails, setCurrentEmails] = useState<Email[]>([]);
+    const [currentEmails, setCurrentEmails] = useState<EmailSummary[]>([]);
     const [selectedEmailId, setSelectedEmailId] = useState<string | null>(null);
     const [isLoading, setIsLoading] = useState<boolean>(false);
     const [error, setError] = useState<string | null>(null);
 
-    const checkAccount = useCallback(async (accountId: string): Promise<void> => {
-        const exists = await emailService.checkAccount(accountId);
+    const checkAccount = useCallback(async (account: Account): Promise<void> => {
+        const exists = await emailService.checkAccount(account);
         if (!exists) {
             setCurrentAccount(null);
             localStorage.removeItem('tempEmailAccount');
@@ -28,7 +28,7 @@ const App: React.FC = () => {
             try {
                 const account = JSON.parse(savedAccount);
                 setCurrentAccount(account);
-                checkAccount(account.account_id); 
+                checkAccount(account); 
             } catch (e) {
                 console.error('Error parsing saved account:', e);
                 localStorage.removeItem('tempEmailAccount');
@@ -41,7 +41,7 @@ const App: React.FC = () => {
         if (!currentAccount) return;
         setIsLoading(true);
         try {
-            const data = await emailService.getEmails(currentAccount.account_id);
+            const data = await emailService.getEmails(currentAccount);
             setCurrentEmails(data.emails || []);
             setError(null);
         } catch (error) {
@@ -56,9 +56,13 @@ const App: React.FC = () => {
     useEffect(() => {
         if (currentAccount) {
             fetchEmails();
-            // Set up auto-refresh
+            // Refresh as soon as new mail arrives, with polling as a fallback
+            const source = emailService.subscribeEvents(currentAccount, fetchEmails);
             const intervalId = setInterval(fetchEmails, 30000);
-            return () => clearInterval(intervalId);
+            return () => {
+                source.close();
+                clearInterval(intervalId);
+            };
         }
     }, [currentAccount, fetchEmails]);
 
@@ -119,7 +123,7 @@ const App: React.FC = () => {
                     <div className={`email-detail-container ${selectedEmailId ? 'open' : ''}`}>
                         {selectedEmailId && (
                             <EmailDetailSection
-                                accountId={currentAccount?.account_id}
+                                account={currentAccount}
                                 emailId={selectedEmailId}
                                 onClose={closeEmailDetail}
                             />
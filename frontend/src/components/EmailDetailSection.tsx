@@ -1,22 +1,26 @@
 import React, { useState, useEffect } from 'react';
 import { emailService } from '../services/api';
-import { EmailDetailSectionProps, Email } from '../types';
+import { EmailDetailSectionProps, EmailDetailResponse, ExtractedResponse } from '../types';
 import { Icon } from '@iconify/react';
 
-const EmailDetailSection: React.FC<EmailDetailSectionProps> = ({ accountId, emailId, onClose }) => {
-  const [emailDetail, setEmailDetail] = useState<Email | null>(null);
+const EmailDetailSection: React.FC<EmailDetailSectionProps> = ({ account, emailId, onClose }) => {
+  const [detail, setDetail] = useState<EmailDetailResponse | null>(null);
+  const [extracted, setExtracted] = useState<ExtractedResponse | null>(null);
   const [loading, setLoading] = useState<boolean>(true);
   const [error, setError] = useState<string | null>(null);
 
   useEffect(() => {
     const fetchEmailDetail = async (): Promise<void> => {
-      if (!accountId || !emailId) return;
+      if (!account || !emailId) return;
       
       setLoading(true);
       try {
-        const data = await emailService.getEmailDetail(accountId, emailId);
-        setEmailDetail(data.email);
+        const data = await emailService.getEmailDetail(account, emailId);
+        setDetail(data);
         setError(null);
+
+        // Codes and links are a convenience, the email shows without them
+        setExtracted(await emailService.getExtracted(account, emailId).catch(() => null));
       } catch (error) {
         console.error('Error:', error);
         setError('Error loading email details. Please try again.');
@@ -26,7 +30,7 @@ const EmailDetailSection: React.FC<EmailDetailSectionProps> = ({ accountId, emai
     };
 
     fetchEmailDetail();
-  }, [accountId, emailId]);
+  }, [account, emailId]);
 
   if (loading) {
     return (
@@ -46,7 +50,7 @@ const EmailDetailSection: React.FC<EmailDetailSectionProps> = ({ accountId, emai
     );
   }
 
-  if (!emailDetail) {
+  if (!detail) {
     return (
       <div className="card">
         <h2>Email Details</h2>
@@ -55,17 +59,9 @@ const EmailDetailSection: React.FC<EmailDetailSectionProps> = ({ accountId, emai
     );
   }
 
+  const emailDetail = detail.email;
   const receivedDate = new Date(emailDetail.received_at).toLocaleString();
 
-  // Function to safely display HTML content
-  const createMarkup = (htmlContent: string) => {
-    return { dangerouslySetInnerHTML: { __html: htmlContent } };
-  };
-
-  const isHtmlContent = (content: string): boolean => {
-    return content.trim().startsWith('<') && content.includes('>');
-  };
-
   return (
     <div className="card">
       <div className="header">
@@ -79,12 +75,34 @@ const EmailDetailSection: React.FC<EmailDetailSectionProps> = ({ accountId, emai
         <p><strong>From:</strong> {emailDetail.from}</p>
         <p><strong>To:</strong> {emailDetail.to}</p>
         <p><strong>Received:</strong> {receivedDate}</p>
+        {extracted && extracted.codes.length > 0 && (
+          <p><strong>Code:</strong> <code className="email-code">{extracted.codes.join(', ')}</code></p>
+        )}
         <div className="email-content">
-          {isHtmlContent(emailDetail.body) ? 
-            <div {...createMarkup(emailDetail.body)} /> : 
-            emailDetail.body
+          {emailDetail.html && account ? 
+            <iframe
+              title="Email content"
+              className="email-frame"
+              sandbox="allow-popups allow-popups-to-escape-sandbox"
+              src={detail.html_url}
+            /> : 
+            emailDetail.text
           }
         </div>
+        {account && emailDetail.attachments && emailDetail.attachments.length > 0 && (
+          <div className="email-attachments">
+            <p><strong>Attachments:</strong></p>
+            <ul>
+              {emailDetail.attachments.map(attachment => (
+                <li key={attachment.id}>
+                  <a href={detail.attachment_urls[attachment.id]}>
+                    {attachment.filename || `attachment-${attachment.id}`}
+                  </a> <small>({attachment.size} bytes)</small>
+                </li>
+              ))}
+            </ul>
+          </div>
+        )}
       </div>
     </div>
   );
@@ -6,11 +6,12 @@ const EmailListItem: React.FC<EmailListItemProps> = ({ email, isSelected, onClic
   
   return (
     <div 
-      className={`email-item ${isSelected ? 'active' : ''}`} 
+      className={`email-item ${isSelected ? 'active' : ''} ${email.read ? '' : 'unread'}`} 
       onClick={onClick}
     >
-      <strong>{email.subject || '(No Subject)'}</strong>
+      <strong>{email.starred ? '★ ' : ''}{email.subject || '(No Subject)'}</strong>
       <div>From: {email.from}</div>
+      {email.snippet && <div className="email-snippet">{email.has_attachments ? '📎 ' : ''}{email.snippet}</div>}
       <div><small>{date}</small></div>
     </div>
   );
@@ -1,11 +1,19 @@
-import { Account, EmailsResponse, EmailDetailResponse } from '../types';
+import { Account, Email, EmailsResponse, EmailDetailResponse, ExtractedResponse } from '../types';
 
 const API_BASE_URL = import.meta.env.VITE_API_HOST + import.meta.env.VITE_API_BASE;
 
+// Build the base URL of an account's resources
+const accountUrl = (account: Account): string => `${API_BASE_URL}/accounts/${account.account_id}`;
+
+// Authorization header carrying the account's access token
+const authHeaders = (account: Account): HeadersInit => (
+  account.token ? { 'Authorization': `Bearer ${account.token}` } : {}
+);
+
 export const emailService = {
   // Check if an account exists
-  checkAccount: async (accountId: string): Promise<boolean> => {
-    const response = await fetch(`${API_BASE_URL}/accounts/${accountId}`);
+  checkAccount: async (account: Account): Promise<boolean> => {
+    const response = await fetch(accountUrl(account), { headers: authHeaders(account) });
     return response.ok;
   },
 
@@ -26,8 +34,8 @@ export const emailService = {
   },
   
   // Get all emails for an account
-  getEmails: async (accountId: string): Promise<EmailsResponse> => {
-    const response = await fetch(`${API_BASE_URL}/accounts/${accountId}/emails`);
+  getEmails: async (account: Account): Promise<EmailsResponse> => {
+    const response = await fetch(`${accountUrl(account)}/emails`, { headers: authHeaders(account) });
     
     if (!response.ok) {
       throw new Error('Failed to fetch emails');
@@ -37,13 +45,93 @@ export const emailService = {
   },
   
   // Get a specific email's details
-  getEmailDetail: async (accountId: string, emailId: string): Promise<EmailDetailResponse> => {
-    const response = await fetch(`${API_BASE_URL}/accounts/${accountId}/emails/${emailId}`);
+  getEmailDetail: async (account: Account, emailId: string): Promise<EmailDetailResponse> => {
+    const response = await fetch(`${accountUrl(account)}/emails/${emailId}?mark_read=true`, { headers: authHeaders(account) });
     
     if (!response.ok) {
       throw new Error('Failed to fetch email details');
     }
     
     return response.json();
+  },
+
+  // Get the codes and links found in an email
+  getExtracted: async (account: Account, emailId: string): Promise<ExtractedResponse> => {
+    const response = await fetch(`${accountUrl(account)}/emails/${emailId}/extracted`, { headers: authHeaders(account) });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch extracted content');
+    }
+
+    return response.json();
+  },
+
+  // Update the read and starred flags or the labels of an email
+  updateEmail: async (account: Account, emailId: string, update: Partial<Pick<Email, 'read' | 'starred' | 'labels'>>): Promise<EmailDetailResponse> => {
+    const response = await fetch(`${accountUrl(account)}/emails/${emailId}`, {
+      method: 'PATCH',
+      headers: { ...authHeaders(account), 'Content-Type': 'application/json' },
+      body: JSON.stringify(update)
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to update email');
+    }
+
+    return response.json();
+  },
+
+  // Delete a specific email
+  deleteEmail: async (account: Account, emailId: string): Promise<void> => {
+    const response = await fetch(`${accountUrl(account)}/emails/${emailId}`, {
+      method: 'DELETE',
+      headers: authHeaders(account)
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to delete email');
+    }
+  },
+
+  // Delete an account with all its emails
+  deleteAccount: async (account: Account): Promise<void> => {
+    const response = await fetch(accountUrl(account), {
+      method: 'DELETE',
+      headers: authHeaders(account)
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to delete account');
+    }
+  },
+
+  // Subscribe to real-time events of an account. EventSource cannot send headers, so the stream
+  // is opened with a signed URL, fetched again when the server refuses an expired one.
+  subscribeEvents: (account: Account, onEmail: () => void): { close: () => void } => {
+    let source: EventSource | null = null;
+    let closed = false;
+
+    const connect = async (): Promise<void> => {
+      const response = await fetch(`${accountUrl(account)}/events/url`, { headers: authHeaders(account) });
+      if (!response.ok || closed) return;
+
+      const { url } = await response.json();
+      source = new EventSource(url);
+      source.addEventListener('email.received', onEmail);
+      source.onerror = () => {
+        // The browser reconnects by itself unless the server answered with an error
+        if (!closed && source?.readyState === EventSource.CLOSED) {
+          setTimeout(() => connect().catch(console.error), 5000);
+        }
+      };
+    };
+    connect().catch(console.error);
+
+    return {
+      close: () => {
+        closed = true;
+        source?.close();
+      }
+    };
   }
-};
\ No newline at end of file
+};
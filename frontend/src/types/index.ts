@@ -2,27 +2,108 @@
 export interface Account {
     account_id: string;
     email: string;
+    domain?: string;
+    expires_at?: string;
+    token?: string;
     created_at: string;
   }
   
+  // Decoded email header
+  export interface EmailHeader {
+    name: string;
+    value: string;
+  }
+
+  // Email attachment metadata
+  export interface Attachment {
+    id: number;
+    email_id: number;
+    filename: string;
+    content_type: string;
+    size: number;
+    content_id?: string;
+    inline: boolean;
+    checksum: string;
+  }
+
+  // SMTP envelope an email was received with
+  export interface Envelope {
+    mail_from: string;
+    rcpt_to: string;
+    client_ip: string;
+    helo: string;
+    tls: boolean;
+    tls_version?: string;
+    tls_cipher_suite?: string;
+    tls_server_name?: string;
+    auth_user?: string;
+  }
+
   // Email message structure
   export interface Email {
     id: string;
     from: string;
     to: string;
+    cc?: string;
     subject: string;
-    body: string;
+    tag?: string;
+    message_id?: string;
+    sent_at?: string;
+    text: string;
+    html: string;
+    headers?: EmailHeader[];
+    attachments?: Attachment[];
+    envelope: Envelope;
+    size: number;
     received_at: string;
+    read: boolean;
+    starred: boolean;
+    labels?: string[];
   }
   
-  // API response for emails list
+  // Email as listed in an inbox, without bodies
+  export interface EmailSummary {
+    id: string;
+    from: string;
+    to: string;
+    subject: string;
+    tag?: string;
+    snippet: string;
+    size: number;
+    has_attachments: boolean;
+    received_at: string;
+    read: boolean;
+    starred: boolean;
+    labels?: string[];
+  }
+
+  // API response for emails list, a page of summaries
   export interface EmailsResponse {
-    emails: Email[];
+    emails: EmailSummary[];
+    has_more: boolean;
+    next?: number;
+    prev?: number;
   }
   
+  // Verification, reset or unsubscribe link found in an email
+  export interface ExtractedLink {
+    kind: 'verification' | 'reset' | 'unsubscribe';
+    url: string;
+    text?: string;
+  }
+
+  // API response for the codes and links found in an email
+  export interface ExtractedResponse {
+    codes: string[];
+    links: ExtractedLink[];
+  }
+
   // API response for single email
   export interface EmailDetailResponse {
     email: Email;
+    // Signed URLs of the HTML rendering and the attachments, for the browser to load without the token
+    html_url: string;
+    attachment_urls: Record<string, string>;
   }
   
   // Component props
@@ -33,7 +114,7 @@ export interface Account {
   }
   
   export interface InboxSectionProps {
-    emails: Email[];
+    emails: EmailSummary[];
     onRefresh: () => Promise<void>;
     onSelectEmail: (emailId: string) => void;
     selectedEmailId: string | null;
@@ -41,13 +122,13 @@ export interface Account {
   }
   
   export interface EmailListItemProps {
-    email: Email;
+    email: EmailSummary;
     isSelected: boolean;
     onClick: () => void;
   }
   
   export interface EmailDetailSectionProps {
-    accountId?: string;
+    account?: Account;
     emailId: string;
     onClose: () => void;
   }
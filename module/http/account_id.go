@@ -0,0 +1,137 @@
+package http
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/galihrivanto/kotak/config"
+	"github.com/galihrivanto/kotak/log"
+)
+
+// Account ID styles
+const (
+	idStyleRandom = "random"
+	idStyleWords  = "words"
+)
+
+const (
+	defaultIDLength   = 8
+	defaultIDAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+	// maxIDAttempts bounds the retries when a generated ID is already taken
+	maxIDAttempts = 5
+)
+
+var idAdjectives = []string{
+	"amber", "bold", "brave", "bright", "calm", "clever", "cosmic", "crisp",
+	"daring", "eager", "fancy", "fierce", "gentle", "golden", "happy", "hidden",
+	"jolly", "keen", "lively", "lucky", "merry", "mighty", "misty", "nimble",
+	"noble", "polite", "proud", "quick", "quiet", "rapid", "rustic", "shiny",
+	"silent", "silver", "sleepy", "smooth", "snowy", "sunny", "swift", "tidy",
+	"tiny", "vivid", "wandering", "warm", "wild", "wise", "witty", "zesty",
+}
+
+var idAnimals = []string{
+	"badger", "beaver", "bison", "camel", "cobra", "crane", "dingo", "dolphin",
+	"eagle", "falcon", "ferret", "finch", "gecko", "gibbon", "heron", "hippo",
+	"ibis", "iguana", "jackal", "koala", "lemur", "llama", "lynx", "marmot",
+	"moose", "newt", "ocelot", "orca", "otter", "owl", "panda", "pelican",
+	"puffin", "quokka", "rabbit", "raven", "salmon", "seal", "sloth", "stork",
+	"tapir", "tiger", "toucan", "turtle", "walrus", "weasel", "yak", "zebra",
+}
+
+// idGenerator creates account IDs using a cryptographically secure source
+type idGenerator struct {
+	style    string
+	length   int
+	alphabet string
+}
+
+// newIDGenerator creates a generator from config, falling back to defaults for invalid settings
+func newIDGenerator(cfg config.Account) *idGenerator {
+	g := &idGenerator{
+		style:    strings.ToLower(cfg.IDStyle),
+		length:   cfg.IDLength,
+		alphabet: cfg.IDAlphabet,
+	}
+
+	if g.style != idStyleWords {
+		g.style = idStyleRandom
+	}
+
+	if g.length <= 0 {
+		g.length = defaultIDLength
+	}
+
+	if len(g.alphabet) < 2 || !isValidAlphabet(g.alphabet) {
+		if g.alphabet != "" {
+			log.Warn("Invalid account ID alphabet %q, using default", g.alphabet)
+		}
+		g.alphabet = defaultIDAlphabet
+	}
+
+	return g
+}
+
+// Generate returns a new account ID
+func (g *idGenerator) Generate() (string, error) {
+	if g.style == idStyleWords {
+		return g.words()
+	}
+	return g.random()
+}
+
+// random returns a string of the configured length drawn from the alphabet
+func (g *idGenerator) random() (string, error) {
+	b := make([]byte, g.length)
+	for i := range b {
+		n, err := randomInt(len(g.alphabet))
+		if err != nil {
+			return "", err
+		}
+		b[i] = g.alphabet[n]
+	}
+	return string(b), nil
+}
+
+// words returns a human friendly ID such as brave-otter-42
+func (g *idGenerator) words() (string, error) {
+	adjective, err := randomInt(len(idAdjectives))
+	if err != nil {
+		return "", err
+	}
+
+	animal, err := randomInt(len(idAnimals))
+	if err != nil {
+		return "", err
+	}
+
+	number, err := randomInt(100)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-%s-%d", idAdjectives[adjective], idAnimals[animal], number), nil
+}
+
+// randomInt returns a uniform random number in [0, max)
+func randomInt(max int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Int64()), nil
+}
+
+// isValidAlphabet reports whether every character can be used unquoted in an email local part
+func isValidAlphabet(alphabet string) bool {
+	for _, r := range alphabet {
+		isAlnum := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+		if !isAlnum && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
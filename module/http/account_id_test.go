@@ -0,0 +1,50 @@
+package http
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/galihrivanto/kotak/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIDGenerator(t *testing.T) {
+	testCases := []struct {
+		name    string
+		cfg     config.Account
+		pattern string
+	}{
+		{
+			name:    "defaults",
+			cfg:     config.Account{},
+			pattern: `^[a-z0-9]{8}$`,
+		},
+		{
+			name:    "custom length and alphabet",
+			cfg:     config.Account{IDLength: 12, IDAlphabet: "ab"},
+			pattern: `^[ab]{12}$`,
+		},
+		{
+			name:    "invalid alphabet falls back to default",
+			cfg:     config.Account{IDAlphabet: "a@b"},
+			pattern: `^[a-z0-9]{8}$`,
+		},
+		{
+			name:    "words",
+			cfg:     config.Account{IDStyle: "words"},
+			pattern: `^[a-z]+-[a-z]+-[0-9]{1,2}$`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newIDGenerator(tc.cfg)
+			for i := 0; i < 20; i++ {
+				id, err := g.Generate()
+				require.NoError(t, err)
+				assert.Regexp(t, regexp.MustCompile(tc.pattern), id)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/galihrivanto/kotak/fault"
+	"github.com/galihrivanto/kotak/log"
+	echo "github.com/labstack/echo/v4"
+)
+
+// getFaults returns the current SMTP fault injection settings
+func (s *Server) getFaults(c echo.Context) error {
+	return c.JSON(http.StatusOK, fault.Default().Settings())
+}
+
+// updateFaults replaces the SMTP fault injection settings
+func (s *Server) updateFaults(c echo.Context) error {
+	var settings fault.Settings
+	if err := c.Bind(&settings); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid fault settings",
+		})
+	}
+
+	if err := fault.Default().Configure(settings); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	log.Info("Fault injection updated, enabled: %v", settings.Enabled)
+	return c.JSON(http.StatusOK, fault.Default().Settings())
+}
+
+// resetFaults disables fault injection and clears all rules
+func (s *Server) resetFaults(c echo.Context) error {
+	if err := fault.Default().Configure(fault.Settings{}); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	log.Info("Fault injection reset")
+	return c.JSON(http.StatusOK, fault.Default().Settings())
+}
@@ -0,0 +1,152 @@
+package http
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/galihrivanto/kotak/config"
+	"github.com/galihrivanto/kotak/db"
+	echo "github.com/labstack/echo/v4"
+)
+
+// accountTokenHeader is an alternative to the Authorization header for account tokens
+const accountTokenHeader = "X-Account-Token"
+
+// generateAccountToken returns a new random access token and the hash to store for it
+func generateAccountToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashAccountToken(token), nil
+}
+
+// hashAccountToken hashes a token for storage; tokens are random so a plain digest suffices
+func hashAccountToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// accountToken extracts the account token from the bearer header or the X-Account-Token header.
+// Tokens are never taken from the query, where they would end up in logs, history and Referer headers.
+func accountToken(c echo.Context) string {
+	if auth := c.Request().Header.Get(echo.HeaderAuthorization); auth != "" {
+		if scheme, token, ok := strings.Cut(auth, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+
+	return c.Request().Header.Get(accountTokenHeader)
+}
+
+// authorizeAccount only lets requests carrying the account's token through.
+// Accounts without a stored token hash, such as catch-all inboxes, follow the catch-all access of their domain.
+//
+// With browser set, for routes loaded by the browser itself such as EventSource streams,
+// iframes and attachment links, a signed URL of the requested path is accepted instead of the token.
+// Clients get those URLs from authenticated requests, see signedURL.
+func (s *Server) authorizeAccount(browser bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			// Account names are stored in lower case, handlers see the stored ID
+			account, err := s.db.GetAccount(strings.ToLower(c.Param("id")))
+			if err != nil {
+				return c.JSON(http.StatusNotFound, map[string]string{
+					"error": "Account not found or deleted",
+				})
+			}
+			setParam(c, "id", account.ID)
+
+			if browser && s.signer.verify(c, c.Request().URL.Path) {
+				return next(c)
+			}
+
+			hash := hashAccountToken(accountToken(c))
+			if account.TokenHash == "" && !s.catchAllAllowed(c, account, hash) {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"error": "Invalid or missing account token",
+				})
+			}
+			if account.TokenHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(account.TokenHash)) != 1 {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"error": "Invalid or missing account token",
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// setParam replaces the value of a path parameter for the handlers that follow
+func setParam(c echo.Context, name, value string) {
+	values := c.ParamValues()
+	for i, n := range c.ParamNames() {
+		if n == name {
+			values[i] = value
+		}
+	}
+	c.SetParamValues(values...)
+}
+
+// catchAllAllowed reports whether a request to an account without its own token is allowed,
+// given the hash of the token it carries
+func (s *Server) catchAllAllowed(c echo.Context, account *db.Account, hash string) bool {
+	domain := s.accountDomain(account)
+	if domain.CatchAllToken != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(hashAccountToken(domain.CatchAllToken))) == 1 {
+		return true
+	}
+
+	switch domain.CatchAllAccess {
+	case config.CatchAllAccessOpen:
+		return true
+	case config.CatchAllAccessRead:
+		method := c.Request().Method
+		return method == http.MethodGet || method == http.MethodHead
+	default:
+		return false
+	}
+}
+
+// redactedURI returns the request URI with secrets in the query replaced, for access logs
+func redactedURI(r *http.Request) string {
+	query := r.URL.Query()
+	redacted := false
+	for _, name := range []string{"token", "signature"} {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return r.RequestURI
+	}
+	return r.URL.Path + "?" + query.Encode()
+}
+
+// authorizeAdmin only lets requests carrying the configured admin token through
+func (s *Server) authorizeAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := ""
+		if auth := c.Request().Header.Get(echo.HeaderAuthorization); auth != "" {
+			if scheme, t, ok := strings.Cut(auth, " "); ok && strings.EqualFold(scheme, "Bearer") {
+				token = strings.TrimSpace(t)
+			}
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.HttpServer.AdminToken)) != 1 {
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"error": "Invalid or missing admin token",
+			})
+		}
+
+		return next(c)
+	}
+}
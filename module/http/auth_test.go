@@ -0,0 +1,115 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/galihrivanto/kotak/config"
+	"github.com/galihrivanto/kotak/db"
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountToken(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/accounts/a/emails?token=secret", nil)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+
+	assert.Empty(t, accountToken(c))
+
+	req.Header.Set(accountTokenHeader, "custom")
+	assert.Equal(t, "custom", accountToken(c))
+
+	req.Header.Set("Authorization", "Bearer header")
+	assert.Equal(t, "header", accountToken(c))
+}
+
+func TestRedactedURI(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/accounts/a/events?token=secret&x=1", nil)
+	assert.Equal(t, "/api/accounts/a/events?token=REDACTED&x=1", redactedURI(req))
+
+	req = httptest.NewRequest("GET", "/api/accounts/a/emails/1/attachments/2?expires=1&signature=abc", nil)
+	assert.Equal(t, "/api/accounts/a/emails/1/attachments/2?expires=1&signature=REDACTED", redactedURI(req))
+
+	req = httptest.NewRequest("GET", "/api/accounts/a/emails?tag=x", nil)
+	assert.Equal(t, "/api/accounts/a/emails?tag=x", redactedURI(req))
+}
+
+func TestCatchAllAllowed(t *testing.T) {
+	cfg := &config.Config{SmtpServer: config.SmtpServer{
+		CatchAllToken: "shared",
+		Domains: []config.Domain{
+			{Name: "closed.test"},
+			{Name: "read.test", CatchAllAccess: config.CatchAllAccessRead},
+			{Name: "open.test", CatchAllAccess: config.CatchAllAccessOpen},
+		},
+	}}
+	s := &Server{cfg: cfg}
+
+	allowed := func(method, domain, token string) bool {
+		c := echo.New().NewContext(httptest.NewRequest(method, "/api/accounts/a", nil), httptest.NewRecorder())
+		return s.catchAllAllowed(c, &db.Account{ID: "a", Domain: domain}, hashAccountToken(token))
+	}
+
+	assert.False(t, allowed("GET", "closed.test", ""))
+	assert.False(t, allowed("GET", "closed.test", "guess"))
+	assert.True(t, allowed("DELETE", "closed.test", "shared"))
+	assert.True(t, allowed("GET", "read.test", ""))
+	assert.False(t, allowed("DELETE", "read.test", ""))
+	assert.True(t, allowed("DELETE", "open.test", ""))
+}
+
+// newAuthTestServer returns a server with the account jane and its token
+func newAuthTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+
+	database, err := db.New(config.Database{Driver: "sqlite", Database: filepath.Join(t.TempDir(), "kotak")})
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+
+	token, hash, err := generateAccountToken()
+	require.NoError(t, err)
+	require.NoError(t, database.CreateAccount(&db.Account{ID: "jane", TokenHash: hash}))
+
+	cfg := &config.Config{HttpServer: config.HttpServer{APIBase: "/api"}}
+	return &Server{cfg: cfg, db: database, signer: newURLSigner()}, token
+}
+
+func TestAuthorizeAccountLowerCase(t *testing.T) {
+	s, token := newAuthTestServer(t)
+	e := echo.New()
+	e.GET("/api/accounts/:id", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Param("id"))
+	}, s.authorizeAccount(false))
+
+	req := httptest.NewRequest("GET", "/api/accounts/Jane", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "jane", rec.Body.String())
+}
+
+func TestAuthorizeBrowserRoutes(t *testing.T) {
+	s, token := newAuthTestServer(t)
+	e := echo.New()
+	e.GET("/api/accounts/:id/events", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, s.authorizeAccount(true))
+
+	get := func(target string) int {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get("/api/accounts/jane/events?token="+token))
+	assert.Equal(t, http.StatusOK, get(s.signedURL("jane", "/events")))
+
+	// A signature is only good for the path it was made for
+	_, query, _ := strings.Cut(s.signedURL("jane", "/emails/1/html"), "?")
+	assert.Equal(t, http.StatusUnauthorized, get("/api/accounts/jane/events?"+query))
+}
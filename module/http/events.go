@@ -0,0 +1,78 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/galihrivanto/kotak/event"
+	echo "github.com/labstack/echo/v4"
+)
+
+// eventsHeartbeat keeps idle event streams alive through proxies
+const eventsHeartbeat = 30 * time.Second
+
+// getEventsURL returns a signed URL of the event stream, EventSource cannot send the token in a header
+func (s *Server) getEventsURL(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"url":        s.signedURL(c.Param("id"), "/events"),
+		"expires_in": int(signedURLLifetime.Seconds()),
+	})
+}
+
+// streamEvents pushes account events to the client as Server-Sent Events
+func (s *Server) streamEvents(c echo.Context) error {
+	accountID := c.Param("id")
+
+	exists, err := s.db.AccountExists(accountID)
+	if err != nil || !exists {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Account not found",
+		})
+	}
+
+	sub := event.Subscribe(accountID)
+	defer sub.Close()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	// Tell the client how long to wait before reconnecting
+	fmt.Fprintf(res, "retry: %d\n\n", (5 * time.Second).Milliseconds())
+	res.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-s.ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			fmt.Fprint(res, ": heartbeat\n\n")
+			res.Flush()
+		case e, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+
+			if e.Email != nil {
+				fmt.Fprintf(res, "id: %d\n", e.Email.ID)
+			}
+			fmt.Fprintf(res, "event: %s\ndata: %s\n\n", e.Type, data)
+			res.Flush()
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/galihrivanto/kotak/db"
+	"github.com/galihrivanto/kotak/extract"
+	"github.com/galihrivanto/kotak/log"
+	echo "github.com/labstack/echo/v4"
+)
+
+// extractedLink is a verification, reset or unsubscribe link found in an email
+type extractedLink struct {
+	Kind string `json:"kind"`
+	URL  string `json:"url"`
+	Text string `json:"text,omitempty"`
+}
+
+// getExtracted returns the codes and links found in a specific email
+func (s *Server) getExtracted(c echo.Context) error {
+	accountID := c.Param("id")
+
+	emailID, err := strconv.ParseInt(c.Param("email_id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid email ID",
+		})
+	}
+
+	extractions, err := s.db.GetExtractions(emailID, accountID)
+	if errors.Is(err, db.ErrEmailNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Email not found",
+		})
+	}
+	if err != nil {
+		log.Error("Failed to get extractions of email %d: %v", emailID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to fetch extracted content",
+		})
+	}
+
+	codes, links := []string{}, []extractedLink{}
+	for _, e := range extractions {
+		if e.Kind == string(extract.Code) {
+			codes = append(codes, e.Value)
+		} else {
+			links = append(links, extractedLink{Kind: e.Kind, URL: e.Value, Text: e.Text})
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"codes": codes,
+		"links": links,
+	})
+}
+
+// getLatestCode returns the first code of the most recent email matching the filter in the query parameters
+func (s *Server) getLatestCode(c echo.Context) error {
+	accountID := c.Param("id")
+
+	filter, err := emailFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	code, err := s.db.LatestExtraction(accountID, string(extract.Code), filter)
+	if err != nil {
+		log.Error("Failed to get latest code of account %s: %v", accountID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to fetch latest code",
+		})
+	}
+	if code == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "No code found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":     code.Value,
+		"email_id": code.EmailID,
+	})
+}
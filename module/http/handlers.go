@@ -1,51 +1,282 @@
 package http
 
 import (
+	"errors"
 	"fmt"
+	"html"
+	"mime"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/galihrivanto/kotak/account"
+	"github.com/galihrivanto/kotak/config"
+	"github.com/galihrivanto/kotak/db"
 	"github.com/galihrivanto/kotak/log"
 	echo "github.com/labstack/echo/v4"
-	"golang.org/x/exp/rand"
 )
 
-// checkAccount checks if an account exists
+// checkAccount checks if an account exists, reporting its expiry and usage
 func (s *Server) checkAccount(c echo.Context) error {
-	accountID := c.Param("id")
-	exists, err := s.db.AccountExists(accountID)
-	if err != nil || !exists {
+	acc, err := s.db.GetAccount(c.Param("id"))
+	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{
 			"error": "Account not found or deleted",
 		})
 	}
 
+	usage, err := s.db.GetUsage(acc.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get account usage",
+		})
+	}
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"exists": exists,
+		"exists":     true,
+		"expires_at": s.accountExpiry(acc),
+		"usage":      usage,
+		"quota": map[string]int64{
+			"max_messages": s.cfg.Inbox.MaxMessages,
+			"max_bytes":    s.cfg.Inbox.MaxStorage,
+		},
 	})
 }
 
+// createAccountRequest is the optional body of an account creation request
+type createAccountRequest struct {
+	// Name is the requested local part, a random ID is generated when empty
+	Name string `json:"name"`
+	// Domain is one of the accepted domains, the default domain is used when empty
+	Domain string `json:"domain"`
+	// TTL is the lifetime of the account such as "30m", the domain's default when empty
+	TTL string `json:"ttl"`
+}
+
 // createAccount handles the creation of new temporary email accounts
 func (s *Server) createAccount(c echo.Context) error {
-	// Generate a random account ID
-	accountID := generateAccountID(8)
+	var req createAccountRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	// Pick the domain the address is created on
+	domain := s.cfg.Domains()[0]
+	if req.Domain != "" {
+		var ok bool
+		if domain, ok = s.cfg.Domain(req.Domain); !ok {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Domain is not accepted",
+			})
+		}
+	}
+
+	ttl, err := parseTTL(req.TTL, domain)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Invalid TTL: %v", err),
+		})
+	}
+	expiresAt := time.Now().Add(ttl)
+
+	// Generate the access token, only its hash is kept
+	token, tokenHash, err := generateAccountToken()
+	if err != nil {
+		log.Error("Failed to generate account token: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create account",
+		})
+	}
+
+	// Use the requested name when given
+	if req.Name != "" {
+		name, err := account.NormalizeName(req.Name, s.cfg.Account.ReservedNames)
+		if errors.Is(err, account.ErrNameReserved) {
+			return c.JSON(http.StatusConflict, map[string]string{
+				"error": "Name is reserved",
+			})
+		}
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("Invalid name: %v", err),
+			})
+		}
+
+		acc := &db.Account{ID: name, Domain: domain.Name, TokenHash: tokenHash, ExpiresAt: &expiresAt}
+		if err := s.db.CreateAccount(acc); err != nil {
+			if errors.Is(err, db.ErrAccountExists) {
+				// Names are unique across domains, as they identify the account in the API
+				if existing, err := s.db.GetAccount(name); err == nil && existing.Domain != "" && !strings.EqualFold(existing.Domain, domain.Name) {
+					return c.JSON(http.StatusConflict, map[string]string{
+						"error": fmt.Sprintf("Name is already taken on %s, account names are unique across domains", existing.Domain),
+					})
+				}
+				return c.JSON(http.StatusConflict, map[string]string{
+					"error": "Name is already taken",
+				})
+			}
+
+			log.Error("Failed to create account %s: %v", name, err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to create account",
+			})
+		}
+
+		return s.accountCreated(c, acc, token)
+	}
+
+	// Generate a random account ID, retrying when it is already taken
+	var acc *db.Account
+	for attempt := 1; ; attempt++ {
+		accountID, err := s.ids.Generate()
+		if err != nil {
+			log.Error("Failed to generate account ID: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to create account",
+			})
+		}
+
+		acc = &db.Account{ID: accountID, Domain: domain.Name, TokenHash: tokenHash, ExpiresAt: &expiresAt}
+		err = s.db.CreateAccount(acc)
+		if err == nil {
+			break
+		}
+
+		if errors.Is(err, db.ErrAccountExists) && attempt < maxIDAttempts {
+			log.Warn("Account ID %s already taken, retrying", accountID)
+			continue
+		}
 
-	// Store in database
-	if err := s.db.CreateAccount(accountID); err != nil {
 		log.Error("Failed to create account %s: %v", accountID, err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to create account",
 		})
 	}
 
-	return c.JSON(http.StatusCreated, map[string]string{
-		"account_id": accountID,
-		"email":      fmt.Sprintf("%s@%s", accountID, s.cfg.SmtpServer.Hostname),
+	return s.accountCreated(c, acc, token)
+}
+
+// accountCreated responds with the details of a newly created account
+func (s *Server) accountCreated(c echo.Context, acc *db.Account, token string) error {
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"account_id": acc.ID,
+		"email":      fmt.Sprintf("%s@%s", acc.ID, acc.Domain),
+		"domain":     acc.Domain,
+		"token":      token,
+		"expires_at": acc.ExpiresAt,
+	})
+}
+
+// extendAccountRequest is the optional body of an account extension request
+type extendAccountRequest struct {
+	// TTL is added to the current expiry, the domain's default lifetime when empty
+	TTL string `json:"ttl"`
+}
+
+// extendAccount pushes the expiry of an account out, never further than the configured maximum from now
+func (s *Server) extendAccount(c echo.Context) error {
+	var req extendAccountRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	acc, err := s.db.GetAccount(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Account not found or deleted",
+		})
+	}
+
+	domain := s.accountDomain(acc)
+	ttl, err := parseTTL(req.TTL, domain)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Invalid TTL: %v", err),
+		})
+	}
+
+	now := time.Now()
+	expiresAt := s.accountExpiry(acc)
+	if expiresAt.Before(now) {
+		expiresAt = now
+	}
+	expiresAt = expiresAt.Add(ttl)
+	if limit := now.Add(domain.AccountMaxTTL()); expiresAt.After(limit) {
+		expiresAt = limit
+	}
+
+	err = s.db.ExtendAccount(acc.ID, expiresAt)
+	if errors.Is(err, db.ErrAccountNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Account not found or deleted",
+		})
+	}
+	if err != nil {
+		log.Error("Failed to extend account %s: %v", acc.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to extend account",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"account_id": acc.ID,
+		"expires_at": expiresAt,
+	})
+}
+
+// parseTTL parses a requested account lifetime, bounded by the maximum of the domain
+func parseTTL(raw string, domain config.Domain) (time.Duration, error) {
+	if raw == "" {
+		return min(domain.AccountTTL(), domain.AccountMaxTTL()), nil
+	}
+
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		return 0, errors.New("use a positive duration such as 30m or 2h")
+	}
+	if ttl > domain.AccountMaxTTL() {
+		return 0, fmt.Errorf("exceeds the maximum of %v", domain.AccountMaxTTL())
+	}
+	return ttl, nil
+}
+
+// accountDomain returns the policy of the domain an account belongs to
+func (s *Server) accountDomain(acc *db.Account) config.Domain {
+	if domain, ok := s.cfg.Domain(acc.Domain); ok {
+		return domain
+	}
+	return s.cfg.Domains()[0]
+}
+
+// accountExpiry returns when an account is deleted; accounts without a recorded expiry
+// live for the lifetime of their domain
+func (s *Server) accountExpiry(acc *db.Account) time.Time {
+	if acc.ExpiresAt != nil {
+		return *acc.ExpiresAt
+	}
+	return acc.CreatedAt.Add(s.accountDomain(acc).AccountTTL())
+}
+
+// getDomains lists the domains accounts can be created on
+func (s *Server) getDomains(c echo.Context) error {
+	var names []string
+	for _, d := range s.cfg.Domains() {
+		names = append(names, d.Name)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"domains": names,
 	})
 }
 
-// getEmails retrieves all emails for an account
+// getEmails lists a page of email summaries for an account
 func (s *Server) getEmails(c echo.Context) error {
 	accountID := c.Param("id")
 
@@ -58,16 +289,260 @@ func (s *Server) getEmails(c echo.Context) error {
 		})
 	}
 
+	filter, err := emailFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	page, err := emailPage(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
 	// Get emails from database
-	emails, err := s.db.GetEmails(accountID)
+	list, err := s.db.ListEmails(accountID, filter, page)
+	if errors.Is(err, db.ErrCursorNotFound) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Unknown before or after email ID",
+		})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to fetch emails",
 		})
 	}
 
+	response := map[string]interface{}{
+		"emails":   list.Emails,
+		"has_more": list.HasMore,
+	}
+
+	// Cursors to the neighbouring pages, present only when there is one
+	if n := len(list.Emails); n > 0 {
+		if (page.Before == 0 && list.HasMore) || page.Before > 0 {
+			response["next"] = list.Emails[n-1].ID
+		}
+		if (page.Before > 0 && list.HasMore) || page.After > 0 {
+			response["prev"] = list.Emails[0].ID
+		}
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// Page sizes of email listings
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// emailPage reads the pagination and sort options from the query parameters
+func emailPage(c echo.Context) (db.EmailPage, error) {
+	page := db.EmailPage{
+		Limit: defaultPageLimit,
+		Sort:  c.QueryParam("sort"),
+	}
+
+	switch page.Sort {
+	case "", db.SortReceivedAt, db.SortSize, db.SortSubject, db.SortFrom:
+	default:
+		return page, fmt.Errorf("Invalid sort parameter, expected one of received_at, size, subject or from")
+	}
+
+	switch c.QueryParam("order") {
+	case "", "desc":
+	case "asc":
+		page.Ascending = true
+	default:
+		return page, fmt.Errorf("Invalid order parameter, expected asc or desc")
+	}
+
+	if raw := c.QueryParam("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return page, fmt.Errorf("Invalid limit parameter, expected 1 to %d", maxPageLimit)
+		}
+		page.Limit = limit
+	}
+
+	var err error
+	if page.Before, err = cursorParam(c, "before"); err != nil {
+		return page, err
+	}
+	if page.After, err = cursorParam(c, "after"); err != nil {
+		return page, err
+	}
+	if page.Before > 0 && page.After > 0 {
+		return page, fmt.Errorf("Only one of before and after can be given")
+	}
+
+	return page, nil
+}
+
+// cursorParam reads an optional email ID cursor from the query parameters
+func cursorParam(c echo.Context, name string) (int64, error) {
+	raw := c.QueryParam(name)
+	if raw == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("Invalid %s parameter", name)
+	}
+	return id, nil
+}
+
+// emailFilter reads the email filter from the query parameters
+func emailFilter(c echo.Context) (db.EmailFilter, error) {
+	filter := db.EmailFilter{
+		Tag:     c.QueryParam("tag"),
+		Label:   c.QueryParam("label"),
+		From:    c.QueryParam("from"),
+		To:      c.QueryParam("to"),
+		Subject: c.QueryParam("subject"),
+		Query:   c.QueryParam("q"),
+	}
+
+	var err error
+	if filter.Read, err = boolParam(c, "read"); err != nil {
+		return filter, err
+	}
+	if filter.Starred, err = boolParam(c, "starred"); err != nil {
+		return filter, err
+	}
+	if filter.HasAttachments, err = boolParam(c, "has_attachments"); err != nil {
+		return filter, err
+	}
+	if filter.Since, err = timeParam(c, "since"); err != nil {
+		return filter, err
+	}
+	if filter.Until, err = timeParam(c, "until"); err != nil {
+		return filter, err
+	}
+
+	// Headers are given as "Name" or "Name:value", the parameter can be repeated
+	for _, raw := range c.QueryParams()["header"] {
+		name, value, _ := strings.Cut(raw, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if name == "" {
+			return filter, fmt.Errorf("Invalid header parameter, expected name:value")
+		}
+		filter.Headers = append(filter.Headers, db.HeaderFilter{Name: name, Value: value})
+	}
+	return filter, nil
+}
+
+// timeParam parses an optional time query parameter, either RFC 3339 or a duration before now
+func timeParam(c echo.Context, name string) (*time.Time, error) {
+	raw := c.QueryParam(name)
+	if raw == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return &t, nil
+	}
+	if ago, err := time.ParseDuration(raw); err == nil && ago >= 0 {
+		t := time.Now().Add(-ago)
+		return &t, nil
+	}
+	return nil, fmt.Errorf("Invalid %s parameter, expected RFC 3339 time or a duration such as 10m", name)
+}
+
+// boolParam parses an optional boolean query parameter, nil when it is absent
+func boolParam(c echo.Context, name string) (*bool, error) {
+	raw := c.QueryParam(name)
+	if raw == "" {
+		return nil, nil
+	}
+
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s parameter", name)
+	}
+	return &value, nil
+}
+
+// deleteEmails deletes all emails of an account, or those matching the filter in the query parameters
+func (s *Server) deleteEmails(c echo.Context) error {
+	accountID := c.Param("id")
+
+	filter, err := emailFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	deleted, err := s.db.DeleteEmails(accountID, filter)
+	if err != nil {
+		log.Error("Failed to delete emails of account %s: %v", accountID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to delete emails",
+		})
+	}
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"emails": emails,
+		"deleted": deleted,
+	})
+}
+
+// deleteEmail deletes a specific email with its attachments
+func (s *Server) deleteEmail(c echo.Context) error {
+	accountID := c.Param("id")
+
+	emailID, err := strconv.ParseInt(c.Param("email_id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid email ID",
+		})
+	}
+
+	if err := s.db.DeleteEmail(emailID, accountID); err != nil {
+		if errors.Is(err, db.ErrEmailNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "Email not found",
+			})
+		}
+
+		log.Error("Failed to delete email %d of account %s: %v", emailID, accountID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to delete email",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"deleted": 1,
+	})
+}
+
+// deleteAccount deletes an account with all its emails and attachments
+func (s *Server) deleteAccount(c echo.Context) error {
+	accountID := c.Param("id")
+
+	report, err := s.db.DeleteAccount(accountID)
+	if err != nil {
+		if errors.Is(err, db.ErrAccountNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "Account not found or deleted",
+			})
+		}
+
+		log.Error("Failed to delete account %s: %v", accountID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to delete account",
+		})
+	}
+
+	log.Info("Deleted account %s with %d emails", accountID, report.Emails)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"account_id":     accountID,
+		"deleted_emails": report.Emails,
 	})
 }
 
@@ -93,22 +568,330 @@ func (s *Server) getEmail(c echo.Context) error {
 		})
 	}
 
+	markRead, err := boolParam(c, "mark_read")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if markRead == nil {
+		markRead = &s.cfg.Inbox.MarkReadOnOpen
+	}
+	if *markRead && !email.Read {
+		if err := s.db.UpdateEmail(emailID, accountID, db.EmailUpdate{Read: markRead}); err != nil {
+			log.Error("Failed to mark email %d of account %s as read: %v", emailID, accountID, err)
+		} else {
+			email.Read = true
+		}
+	}
+
+	// The rendering and the attachments are loaded by the browser itself, through signed URLs
+	attachmentURLs := map[int64]string{}
+	for _, a := range email.Attachments {
+		attachmentURLs[a.ID] = s.signedURL(accountID, "/emails/%d/attachments/%d", email.ID, a.ID)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"email":           email,
+		"html_url":        s.signedURL(accountID, "/emails/%d/html", email.ID),
+		"attachment_urls": attachmentURLs,
+	})
+}
+
+// Limits on the labels of an email
+const (
+	maxLabels      = 32
+	maxLabelLength = 64
+)
+
+// updateEmailRequest changes the mailbox state of an email, absent fields are left as they are
+type updateEmailRequest struct {
+	Read    *bool `json:"read"`
+	Starred *bool `json:"starred"`
+	// Labels replaces all labels, AddLabels and RemoveLabels change them one by one
+	Labels       *[]string `json:"labels"`
+	AddLabels    []string  `json:"add_labels"`
+	RemoveLabels []string  `json:"remove_labels"`
+}
+
+// updateEmail sets the read and starred flags and the labels of an email
+func (s *Server) updateEmail(c echo.Context) error {
+	accountID := c.Param("id")
+
+	emailID, err := strconv.ParseInt(c.Param("email_id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid email ID",
+		})
+	}
+
+	var req updateEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	update := db.EmailUpdate{Read: req.Read, Starred: req.Starred}
+	if req.Labels != nil {
+		labels, err := normalizeLabels(*req.Labels)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		update.Labels = &labels
+	}
+	if update.AddLabels, err = normalizeLabels(req.AddLabels); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if update.RemoveLabels, err = normalizeLabels(req.RemoveLabels); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	if err := s.db.UpdateEmail(emailID, accountID, update); err != nil {
+		if errors.Is(err, db.ErrEmailNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "Email not found",
+			})
+		}
+
+		log.Error("Failed to update email %d of account %s: %v", emailID, accountID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to update email",
+		})
+	}
+
+	email, err := s.db.GetEmail(emailID, accountID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Email not found",
+		})
+	}
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"email": email,
 	})
 }
 
-// Helper function to generate a random account ID
-func generateAccountID(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[rand.Intn(len(charset))]
+// normalizeLabels trims labels and drops duplicates, rejecting empty or overlong ones
+func normalizeLabels(labels []string) ([]string, error) {
+	if len(labels) > maxLabels {
+		return nil, fmt.Errorf("At most %d labels are allowed", maxLabels)
+	}
+
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label == "" || len(label) > maxLabelLength {
+			return nil, fmt.Errorf("Labels must be 1 to %d characters long", maxLabelLength)
+		}
+		if !seen[label] {
+			seen[label] = true
+			normalized = append(normalized, label)
+		}
 	}
-	return string(b)
+	return normalized, nil
 }
 
-// Initialize random seed
-func init() {
-	rand.Seed(uint64(time.Now().UnixNano()))
+// getRawEmail returns the original message of a specific email
+func (s *Server) getRawEmail(c echo.Context) error {
+	accountID := c.Param("id")
+
+	emailID, err := strconv.ParseInt(c.Param("email_id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid email ID",
+		})
+	}
+
+	raw, err := s.db.GetRawMessage(emailID, accountID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Email not found",
+		})
+	}
+
+	disposition := "inline"
+	if download, _ := strconv.ParseBool(c.QueryParam("download")); download {
+		disposition = "attachment"
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType(disposition, map[string]string{
+		"filename": fmt.Sprintf("%d.eml", emailID),
+	}))
+
+	return c.Blob(http.StatusOK, "message/rfc822", raw.Data)
+}
+
+// getEmailHTML renders a sanitized HTML version of a specific email
+func (s *Server) getEmailHTML(c echo.Context) error {
+	accountID := c.Param("id")
+
+	emailID, err := strconv.ParseInt(c.Param("email_id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid email ID",
+		})
+	}
+
+	email, err := s.db.GetEmail(emailID, accountID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Email not found",
+		})
+	}
+
+	// Readers may only ask for a mode more restrictive than the configured one
+	mode := s.remoteContentMode()
+	if requested := c.QueryParam("remote"); requested != "" {
+		rank := slices.Index(remoteContentModes, requested)
+		if rank < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid remote parameter, expected block, proxy or allow",
+			})
+		}
+		if rank > slices.Index(remoteContentModes, mode) {
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"error": fmt.Sprintf("Remote content is limited to %s", mode),
+			})
+		}
+		mode = requested
+	}
+
+	content := email.HTML
+	if content == "" {
+		content = "<pre>" + html.EscapeString(email.Text) + "</pre>"
+	}
+
+	apiURL := s.cfg.HttpServer.APIHost + s.cfg.HttpServer.APIBase
+	opts := sanitizeOptions{
+		RemoteContent: mode,
+		ContentIDs:    map[string]string{},
+		Proxy: func(raw string) string {
+			return apiURL + "/proxy?url=" + url.QueryEscape(raw) + "&" + s.signer.query(proxyResource(raw))
+		},
+	}
+	for _, a := range email.Attachments {
+		if a.ContentID != "" {
+			// Inline images are loaded by the frame itself
+			opts.ContentIDs[a.ContentID] = s.signedURL(accountID, "/emails/%d/attachments/%d", email.ID, a.ID)
+		}
+	}
+
+	result, err := sanitizeHTML(content, opts)
+	if err != nil {
+		log.Error("Failed to sanitize email %d for account %s: %v", emailID, accountID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to render email",
+		})
+	}
+
+	imgSrc := "'self' data:"
+	if s.cfg.HttpServer.APIHost != "" {
+		imgSrc += " " + s.cfg.HttpServer.APIHost
+	}
+	if mode == remoteContentAllow {
+		imgSrc += " http: https:"
+	}
+
+	header := c.Response().Header()
+	header.Set("Content-Security-Policy", fmt.Sprintf(
+		"default-src 'none'; img-src %s; style-src 'unsafe-inline'; sandbox allow-popups allow-popups-to-escape-sandbox", imgSrc,
+	))
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Referrer-Policy", "no-referrer")
+	header.Set("X-Remote-Content-Blocked", strconv.Itoa(result.Blocked))
+
+	return c.HTML(http.StatusOK, result.HTML)
+}
+
+// remoteContentMode returns the configured remote content mode, blocking by default
+func (s *Server) remoteContentMode() string {
+	switch s.cfg.HttpServer.RemoteContent {
+	case remoteContentProxy, remoteContentAllow:
+		return s.cfg.HttpServer.RemoteContent
+	default:
+		return remoteContentBlock
+	}
+}
+
+// getAttachments lists the attachments of a specific email
+func (s *Server) getAttachments(c echo.Context) error {
+	accountID := c.Param("id")
+
+	emailID, err := strconv.ParseInt(c.Param("email_id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid email ID",
+		})
+	}
+
+	// Make sure the email belongs to the account
+	if _, err := s.db.GetEmail(emailID, accountID); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Email not found",
+		})
+	}
+
+	attachments, err := s.db.GetAttachments(emailID, accountID)
+	if err != nil {
+		log.Error("Failed to get attachments of email %d for account %s: %v", emailID, accountID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to fetch attachments",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"attachments": attachments,
+	})
+}
+
+// downloadAttachment returns the contents of a specific attachment
+func (s *Server) downloadAttachment(c echo.Context) error {
+	accountID := c.Param("id")
+
+	emailID, err := strconv.ParseInt(c.Param("email_id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid email ID",
+		})
+	}
+
+	attachmentID, err := strconv.ParseInt(c.Param("attachment_id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid attachment ID",
+		})
+	}
+
+	attachment, err := s.db.GetAttachment(attachmentID, emailID, accountID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Attachment not found",
+		})
+	}
+
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = echo.MIMEOctetStream
+	}
+
+	filename := attachment.Filename
+	if filename == "" {
+		filename = fmt.Sprintf("attachment-%d", attachment.ID)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{
+		"filename": filename,
+	}))
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	c.Response().Header().Set("Referrer-Policy", "no-referrer")
+
+	return c.Blob(http.StatusOK, contentType, attachment.Data)
 }
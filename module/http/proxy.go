@@ -0,0 +1,156 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/galihrivanto/kotak/log"
+	echo "github.com/labstack/echo/v4"
+)
+
+// maxProxiedImageSize limits the size of images fetched through the proxy
+const maxProxiedImageSize = 5 << 20
+
+var errForbiddenAddress = errors.New("address is not publicly routable")
+
+// proxyClient fetches remote images, refusing to connect to internal addresses
+var proxyClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(_, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if !isPublicIP(net.ParseIP(host)) {
+					return errForbiddenAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 3 {
+			return errors.New("too many redirects")
+		}
+		return nil
+	},
+}
+
+// proxyResource is the signed resource of a proxied image URL
+func proxyResource(raw string) string {
+	return "proxy:" + raw
+}
+
+// proxyImage fetches a remote image on behalf of a rendered HTML email,
+// only for URLs signed when the email was rendered
+func (s *Server) proxyImage(c echo.Context) error {
+	if !s.signer.verify(c, proxyResource(c.QueryParam("url"))) {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": "Invalid or expired image URL signature",
+		})
+	}
+
+	target, err := url.Parse(c.QueryParam("url"))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid image URL",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), proxyClient.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid image URL",
+		})
+	}
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := proxyClient.Do(req)
+	if err != nil {
+		log.Debug("Failed to proxy image %s: %v", target, err)
+		return c.JSON(http.StatusBadGateway, map[string]string{
+			"error": "Failed to fetch image",
+		})
+	}
+	defer resp.Body.Close()
+
+	contentType := resp.Header.Get(echo.HeaderContentType)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "image/svg") {
+		return c.JSON(http.StatusBadGateway, map[string]string{
+			"error": fmt.Sprintf("Remote returned %d %s", resp.StatusCode, contentType),
+		})
+	}
+	if resp.ContentLength > maxProxiedImageSize {
+		return c.JSON(http.StatusBadGateway, map[string]string{
+			"error": "Image too large",
+		})
+	}
+
+	c.Response().Header().Set("Cache-Control", "private, max-age=3600")
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+
+	return c.Stream(http.StatusOK, contentType, io.LimitReader(resp.Body, maxProxiedImageSize))
+}
+
+// nonPublicNetworks are special purpose ranges not covered by the net.IP predicates
+var nonPublicNetworks = parseCIDRs(
+	"0.0.0.0/8",       // "this" network
+	"100.64.0.0/10",   // carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved
+	"64:ff9b::/96",    // NAT64, may map onto internal IPv4 addresses
+	"64:ff9b:1::/48",  // local-use NAT64
+	"100::/64",        // discard
+	"2001:db8::/32",   // documentation
+	"2002::/16",       // 6to4, may embed internal IPv4 addresses
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// isPublicIP reports whether ip is a globally routable unicast address
+func isPublicIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	for _, ip := range []string{"93.184.216.34", "2606:4700::1111"} {
+		assert.True(t, isPublicIP(net.ParseIP(ip)), ip)
+	}
+
+	for _, ip := range []string{
+		"127.0.0.1", "10.1.2.3", "192.168.1.1", "169.254.169.254", "0.0.0.0", "0.1.2.3",
+		"100.64.0.1", "100.127.255.254", "198.18.0.1", "240.0.0.1", "255.255.255.255",
+		"::1", "fd00::1", "fe80::1", "64:ff9b::a00:1", "2002:a00:1::1", "::ffff:10.0.0.1",
+	} {
+		assert.False(t, isPublicIP(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestProxyImageSignature(t *testing.T) {
+	s := &Server{signer: newURLSigner()}
+	e := echo.New()
+	target := "http://127.0.0.1/a.png"
+
+	proxy := func(query string) int {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/proxy?"+query, nil), rec)
+		assert.NoError(t, s.proxyImage(c))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, proxy("url="+url.QueryEscape(target)))
+	assert.Equal(t, http.StatusForbidden, proxy("url="+url.QueryEscape(target+"x")+"&"+s.signer.query(proxyResource(target))))
+
+	// A valid signature gets through to the fetch, which refuses the loopback address
+	assert.Equal(t, http.StatusBadGateway, proxy("url="+url.QueryEscape(target)+"&"+s.signer.query(proxyResource(target))))
+}
@@ -0,0 +1,309 @@
+package http
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Remote content modes for rendering HTML emails
+const (
+	remoteContentBlock = "block"
+	remoteContentProxy = "proxy"
+	remoteContentAllow = "allow"
+)
+
+// remoteContentModes lists the modes from the most to the least restrictive
+var remoteContentModes = []string{remoteContentBlock, remoteContentProxy, remoteContentAllow}
+
+// allowedElements are rendered as is, with their attributes sanitized
+var allowedElements = map[atom.Atom]bool{
+	atom.A: true, atom.Abbr: true, atom.Address: true, atom.Article: true, atom.Aside: true,
+	atom.B: true, atom.Bdi: true, atom.Bdo: true, atom.Big: true, atom.Blockquote: true,
+	atom.Br: true, atom.Caption: true, atom.Center: true, atom.Cite: true, atom.Code: true,
+	atom.Col: true, atom.Colgroup: true, atom.Dd: true, atom.Del: true, atom.Details: true,
+	atom.Dfn: true, atom.Div: true, atom.Dl: true, atom.Dt: true, atom.Em: true,
+	atom.Figcaption: true, atom.Figure: true, atom.Font: true, atom.Footer: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Header: true, atom.Hr: true, atom.I: true, atom.Img: true, atom.Ins: true,
+	atom.Kbd: true, atom.Li: true, atom.Main: true, atom.Mark: true, atom.Nav: true,
+	atom.Ol: true, atom.P: true, atom.Pre: true, atom.Q: true, atom.S: true, atom.Samp: true,
+	atom.Section: true, atom.Small: true, atom.Span: true, atom.Strike: true, atom.Strong: true,
+	atom.Sub: true, atom.Summary: true, atom.Sup: true, atom.Table: true, atom.Tbody: true,
+	atom.Td: true, atom.Tfoot: true, atom.Th: true, atom.Thead: true, atom.Time: true,
+	atom.Tr: true, atom.Tt: true, atom.U: true, atom.Ul: true, atom.Var: true, atom.Wbr: true,
+}
+
+// droppedElements are removed together with their content
+var droppedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Noscript: true, atom.Template: true, atom.Iframe: true,
+	atom.Frame: true, atom.Frameset: true, atom.Object: true, atom.Embed: true,
+	atom.Applet: true, atom.Param: true, atom.Title: true, atom.Meta: true, atom.Link: true,
+	atom.Base: true, atom.Svg: true, atom.Math: true, atom.Canvas: true, atom.Audio: true,
+	atom.Video: true, atom.Source: true, atom.Track: true, atom.Input: true, atom.Button: true,
+	atom.Textarea: true, atom.Select: true, atom.Option: true, atom.Optgroup: true,
+	atom.Datalist: true, atom.Keygen: true, atom.Output: true, atom.Dialog: true,
+}
+
+// allowedAttributes are kept on allowed elements, anything else (including event handlers) is dropped
+var allowedAttributes = map[string]bool{
+	"align": true, "alt": true, "background": true, "bgcolor": true, "border": true,
+	"cellpadding": true, "cellspacing": true, "class": true, "color": true, "colspan": true,
+	"dir": true, "face": true, "height": true, "href": true, "lang": true, "rowspan": true,
+	"size": true, "span": true, "src": true, "start": true, "style": true, "title": true,
+	"type": true, "valign": true, "width": true,
+}
+
+var (
+	cssURLRE    = regexp.MustCompile(`(?i)url\(\s*(['"]?)(.*?)(['"]?)\s*\)`)
+	cssUnsafeRE = regexp.MustCompile(`(?i)expression\s*\(|javascript:|vbscript:|behavior\s*:|-moz-binding|@import|\\`)
+)
+
+// sanitizeOptions controls how resources referenced by an HTML email are rewritten
+type sanitizeOptions struct {
+	// RemoteContent is one of block, proxy or allow
+	RemoteContent string
+	// ContentIDs maps attachment content IDs to their download URL
+	ContentIDs map[string]string
+	// Proxy returns the image proxy URL of a remote image
+	Proxy func(raw string) string
+}
+
+// sanitizeResult is a sanitized HTML document
+type sanitizeResult struct {
+	HTML    string
+	Blocked int
+}
+
+type sanitizer struct {
+	opts    sanitizeOptions
+	blocked int
+}
+
+// sanitizeHTML strips active content from an HTML email so it can be shown in a sandboxed frame
+func sanitizeHTML(input string, opts sanitizeOptions) (*sanitizeResult, error) {
+	doc, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sanitizer{opts: opts}
+	s.sanitizeChildren(doc)
+
+	// The parser always produces html, head and body elements; styles may live in either
+	var styles, body []*html.Node
+	for _, section := range findElements(doc, atom.Head, atom.Body) {
+		for c := section.FirstChild; c != nil; c = c.NextSibling {
+			if section.DataAtom == atom.Head {
+				if c.DataAtom == atom.Style {
+					styles = append(styles, c)
+				}
+				continue
+			}
+			body = append(body, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`<!DOCTYPE html><html><head><meta charset="utf-8"><meta name="referrer" content="no-referrer">`)
+	for _, n := range styles {
+		if err := html.Render(&buf, n); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteString(`</head><body>`)
+	for _, n := range body {
+		if err := html.Render(&buf, n); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteString(`</body></html>`)
+
+	return &sanitizeResult{HTML: buf.String(), Blocked: s.blocked}, nil
+}
+
+// findElements returns the elements with the given atoms in document order
+func findElements(n *html.Node, atoms ...atom.Atom) []*html.Node {
+	var found []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		for _, a := range atoms {
+			if c.DataAtom == a {
+				found = append(found, c)
+			}
+		}
+		found = append(found, findElements(c, atoms...)...)
+	}
+	return found
+}
+
+// sanitizeChildren removes, unwraps or cleans every child of n
+func (s *sanitizer) sanitizeChildren(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+
+		switch c.Type {
+		case html.CommentNode, html.DoctypeNode:
+			n.RemoveChild(c)
+		case html.ElementNode:
+			switch {
+			case c.Namespace != "" || droppedElements[c.DataAtom]:
+				n.RemoveChild(c)
+			case c.DataAtom == atom.Style:
+				s.sanitizeStyleElement(n, c)
+			case c.DataAtom == atom.Html || c.DataAtom == atom.Head || c.DataAtom == atom.Body:
+				c.Attr = nil
+				s.sanitizeChildren(c)
+			case allowedElements[c.DataAtom]:
+				s.sanitizeAttributes(c)
+				s.sanitizeChildren(c)
+			default:
+				// Unknown elements and form wrappers are replaced by their content
+				s.sanitizeChildren(c)
+				for gc := c.FirstChild; gc != nil; gc = c.FirstChild {
+					c.RemoveChild(gc)
+					n.InsertBefore(gc, c)
+				}
+				n.RemoveChild(c)
+			}
+		}
+
+		c = next
+	}
+}
+
+// sanitizeStyleElement rewrites the stylesheet of a style element, removing it when unsafe
+func (s *sanitizer) sanitizeStyleElement(parent, n *html.Node) {
+	var css strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			css.WriteString(c.Data)
+		}
+	}
+
+	sanitized, ok := s.sanitizeCSS(css.String())
+	if !ok {
+		parent.RemoveChild(n)
+		return
+	}
+
+	n.Attr = nil
+	for c := n.FirstChild; c != nil; c = n.FirstChild {
+		n.RemoveChild(c)
+	}
+	n.AppendChild(&html.Node{Type: html.TextNode, Data: sanitized})
+}
+
+// sanitizeAttributes keeps only allowed attributes and rewrites URLs and inline styles
+func (s *sanitizer) sanitizeAttributes(n *html.Node) {
+	attrs := n.Attr[:0]
+	for _, attr := range n.Attr {
+		key := strings.ToLower(attr.Key)
+		if attr.Namespace != "" || !allowedAttributes[key] {
+			continue
+		}
+
+		switch key {
+		case "href":
+			if !isSafeLink(attr.Val) {
+				continue
+			}
+		case "src", "background":
+			attr.Val = s.resourceURL(attr.Val)
+			if attr.Val == "" {
+				continue
+			}
+		case "style":
+			val, ok := s.sanitizeCSS(attr.Val)
+			if !ok {
+				continue
+			}
+			attr.Val = val
+		}
+
+		attr.Key = key
+		attrs = append(attrs, attr)
+	}
+	n.Attr = attrs
+
+	// Links always open outside of the frame
+	if n.DataAtom == atom.A {
+		n.Attr = append(n.Attr,
+			html.Attribute{Key: "target", Val: "_blank"},
+			html.Attribute{Key: "rel", Val: "noopener noreferrer"},
+		)
+	}
+}
+
+// sanitizeCSS rewrites url() references, reporting false when the CSS cannot be made safe
+func (s *sanitizer) sanitizeCSS(css string) (string, bool) {
+	if cssUnsafeRE.MatchString(css) {
+		return "", false
+	}
+
+	return cssURLRE.ReplaceAllStringFunc(css, func(match string) string {
+		parts := cssURLRE.FindStringSubmatch(match)
+		if u := s.resourceURL(parts[2]); u != "" {
+			return `url("` + strings.ReplaceAll(u, `"`, "%22") + `")`
+		}
+		return "none"
+	}), true
+}
+
+// resourceURL rewrites the URL of an embedded resource, returning an empty string when it must not load
+func (s *sanitizer) resourceURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	lower := strings.ToLower(raw)
+
+	switch {
+	case strings.HasPrefix(lower, "cid:"):
+		contentID, err := url.PathUnescape(raw[len("cid:"):])
+		if err != nil {
+			return ""
+		}
+		return s.opts.ContentIDs[strings.Trim(contentID, "<>")]
+	case strings.HasPrefix(lower, "data:image/"):
+		return raw
+	case strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "https://"), strings.HasPrefix(lower, "//"):
+		switch s.opts.RemoteContent {
+		case remoteContentAllow:
+			return raw
+		case remoteContentProxy:
+			if strings.HasPrefix(raw, "//") {
+				raw = "https:" + raw
+			}
+			return s.opts.Proxy(raw)
+		default:
+			s.blocked++
+			return ""
+		}
+	default:
+		return ""
+	}
+}
+
+// isSafeLink reports whether a link target uses a scheme that cannot run script
+func isSafeLink(raw string) bool {
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, "#") {
+		return true
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto", "tel":
+		return true
+	default:
+		return false
+	}
+}
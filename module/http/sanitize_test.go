@@ -0,0 +1,84 @@
+package http
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		opts     sanitizeOptions
+		contains []string
+		excludes []string
+		blocked  int
+	}{
+		{
+			name:     "scripts and event handlers",
+			input:    `<p onclick="steal()">Hi<script>alert(1)</script></p><img src=x onerror="alert(1)">`,
+			contains: []string{"<p>Hi</p>", "<img/>"},
+			excludes: []string{"script", "onclick", "onerror", "alert"},
+		},
+		{
+			name:     "forms are unwrapped and controls removed",
+			input:    `<form action="https://evil.test"><label>Password</label><input type="password"><button>Go</button></form>`,
+			contains: []string{"Password"},
+			excludes: []string{"form", "input", "button", "evil.test"},
+		},
+		{
+			name:     "unsafe links",
+			input:    `<a href="javascript:alert(1)">bad</a><a href="https://example.com">good</a>`,
+			contains: []string{`<a target="_blank" rel="noopener noreferrer">bad</a>`, `href="https://example.com"`},
+			excludes: []string{"javascript"},
+		},
+		{
+			name:  "cid images rewritten",
+			input: `<img src="cid:logo@example">`,
+			opts: sanitizeOptions{
+				ContentIDs: map[string]string{"logo@example": "/api/accounts/a/emails/1/attachments/2"},
+			},
+			contains: []string{`src="/api/accounts/a/emails/1/attachments/2"`},
+		},
+		{
+			name:     "remote images blocked",
+			input:    `<img src="https://tracker.test/p.gif"><div style="background: url('https://tracker.test/bg.png')">x</div>`,
+			opts:     sanitizeOptions{RemoteContent: remoteContentBlock},
+			contains: []string{`background: none`},
+			excludes: []string{"tracker.test"},
+			blocked:  2,
+		},
+		{
+			name:  "remote images proxied",
+			input: `<img src="https://example.com/a.png">`,
+			opts: sanitizeOptions{RemoteContent: remoteContentProxy, Proxy: func(raw string) string {
+				return "/api/proxy?url=" + url.QueryEscape(raw)
+			}},
+			contains: []string{`src="/api/proxy?url=https%3A%2F%2Fexample.com%2Fa.png"`},
+		},
+		{
+			name:     "unsafe styles",
+			input:    `<style>@import url(https://evil.test/x.css);</style><p style="width: expression(alert(1))">x</p>`,
+			contains: []string{"<p>x</p>"},
+			excludes: []string{"import", "expression"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := sanitizeHTML(tc.input, tc.opts)
+			require.NoError(t, err)
+
+			for _, s := range tc.contains {
+				assert.Contains(t, result.HTML, s)
+			}
+			for _, s := range tc.excludes {
+				assert.NotContains(t, result.HTML, s)
+			}
+			assert.Equal(t, tc.blocked, result.Blocked)
+		})
+	}
+}
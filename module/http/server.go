@@ -1,9 +1,11 @@
 package http
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	"github.com/galihrivanto/kotak/config"
 	"github.com/galihrivanto/kotak/db"
@@ -19,6 +21,8 @@ type Server struct {
 	cfg    *config.Config
 	db     *db.DB
 	srv    *echo.Echo
+	ids    *idGenerator
+	signer *urlSigner
 }
 
 func (s *Server) Start(ctx context.Context) error {
@@ -50,10 +54,44 @@ func (s *Server) setupAPI() {
 	api := s.srv.Group(s.cfg.HttpServer.APIBase)
 
 	// Account routes
+	api.GET("/domains", s.getDomains)
 	api.POST("/accounts", s.createAccount)
-	api.GET("/accounts/:id", s.checkAccount)
-	api.GET("/accounts/:id/emails", s.getEmails)
-	api.GET("/accounts/:id/emails/:email_id", s.getEmail)
+
+	// Routes below require the account token
+	account := api.Group("/accounts/:id", s.authorizeAccount(false))
+	account.GET("", s.checkAccount)
+	account.DELETE("", s.deleteAccount)
+	account.POST("/extend", s.extendAccount)
+	account.GET("/code", s.getLatestCode)
+	account.GET("/events/url", s.getEventsURL)
+	account.GET("/emails", s.getEmails)
+	account.DELETE("/emails", s.deleteEmails)
+	account.GET("/emails/wait", s.waitEmail)
+	account.GET("/emails/:email_id", s.getEmail)
+	account.PATCH("/emails/:email_id", s.updateEmail)
+	account.DELETE("/emails/:email_id", s.deleteEmail)
+	account.GET("/emails/:email_id/raw", s.getRawEmail)
+	account.GET("/emails/:email_id/extracted", s.getExtracted)
+	account.GET("/emails/:email_id/attachments", s.getAttachments)
+
+	// Routes loaded by the browser itself also take a signed URL instead of the token
+	browser := api.Group("/accounts/:id", s.authorizeAccount(true))
+	browser.GET("/events", s.streamEvents)
+	browser.GET("/emails/:email_id/html", s.getEmailHTML)
+	browser.GET("/emails/:email_id/attachments/:attachment_id", s.downloadAttachment)
+
+	// Admin routes are only available when an admin token is configured
+	if s.cfg.HttpServer.AdminToken != "" {
+		admin := api.Group("/admin", s.authorizeAdmin)
+		admin.GET("/faults", s.getFaults)
+		admin.PUT("/faults", s.updateFaults)
+		admin.DELETE("/faults", s.resetFaults)
+	}
+
+	// Remote images of HTML emails are only fetched when explicitly enabled, through URLs signed while rendering
+	if s.remoteContentMode() != remoteContentBlock {
+		api.GET("/proxy", s.proxyImage)
+	}
 }
 
 func (s *Server) setupStatic() {
@@ -70,12 +108,18 @@ func (s *Server) Close() error {
 }
 
 func NewServer(cfg *config.Config, db *db.DB) *Server {
-	svc := &Server{cfg: cfg, db: db}
+	svc := &Server{cfg: cfg, db: db, ids: newIDGenerator(cfg.Account), signer: newURLSigner()}
 
 	svc.srv = echo.New()
 	svc.srv.HideBanner = true
 
-	svc.srv.Use(middleware.Logger())
+	// Account tokens and URL signatures are kept out of the access log
+	svc.srv.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
+		Format: strings.Replace(middleware.DefaultLoggerConfig.Format, `"uri":"${uri}"`, `"uri":"${custom}"`, 1),
+		CustomTagFunc: func(c echo.Context, buf *bytes.Buffer) (int, error) {
+			return buf.WriteString(redactedURI(c.Request()))
+		},
+	}))
 	svc.srv.Use(middleware.Recover())
 	svc.srv.Use(middleware.CORS())
 
@@ -0,0 +1,68 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+// signedURLLifetime is how long a signed URL handed out in a rendered email stays valid
+const signedURLLifetime = time.Hour
+
+// urlSigner authorizes URLs loaded by the browser itself, such as images of a rendered email,
+// without putting the account token in them. The key is generated at startup, so signed URLs
+// do not survive a restart.
+type urlSigner struct {
+	key []byte
+}
+
+func newURLSigner() *urlSigner {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return &urlSigner{key: key}
+}
+
+// query returns the expires and signature query parameters authorizing the resource
+func (s *urlSigner) query(resource string) string {
+	expires := strconv.FormatInt(time.Now().Add(signedURLLifetime).Unix(), 10)
+	return url.Values{
+		"expires":   {expires},
+		"signature": {s.sign(resource, expires)},
+	}.Encode()
+}
+
+// verify reports whether the request carries an unexpired signature of the resource
+func (s *urlSigner) verify(c echo.Context, resource string) bool {
+	expires, signature := c.QueryParam("expires"), c.QueryParam("signature")
+	if expires == "" || signature == "" {
+		return false
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(s.sign(resource, expires)))
+}
+
+func (s *urlSigner) sign(resource, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(resource + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedURL returns the absolute URL of an account resource the browser loads by itself,
+// signed so that it carries no token
+func (s *Server) signedURL(accountID, format string, args ...interface{}) string {
+	path := fmt.Sprintf("%s/accounts/%s", s.cfg.HttpServer.APIBase, accountID) + fmt.Sprintf(format, args...)
+	return s.cfg.HttpServer.APIHost + path + "?" + s.signer.query(path)
+}
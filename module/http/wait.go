@@ -0,0 +1,185 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/galihrivanto/kotak/db"
+	"github.com/galihrivanto/kotak/event"
+	"github.com/galihrivanto/kotak/log"
+	echo "github.com/labstack/echo/v4"
+)
+
+const (
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 5 * time.Minute
+)
+
+// waitFilter selects the email a waiting client is interested in
+type waitFilter struct {
+	subject string
+	from    string
+	to      string
+	tag     string
+	after   int64
+	since   time.Time
+
+	// existing allows emails stored before the wait started to match
+	existing bool
+}
+
+// matches reports whether the email satisfies every criterion of the filter
+func (f waitFilter) matches(email *db.Email) bool {
+	if f.after > 0 && email.ID <= f.after {
+		return false
+	}
+	if !f.since.IsZero() && email.ReceivedAt.Before(f.since) {
+		return false
+	}
+	if f.tag != "" && email.Tag != f.tag {
+		return false
+	}
+	return containsFold(email.Subject, f.subject) &&
+		containsFold(email.From, f.from) &&
+		containsFold(email.To, f.to)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// parseWaitTimeout accepts a Go duration or a number of seconds
+func parseWaitTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultWaitTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			return 0, err
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	if timeout <= 0 {
+		return defaultWaitTimeout, nil
+	}
+	return min(timeout, maxWaitTimeout), nil
+}
+
+// waitEmail blocks until an email matching the query arrives or the timeout elapses.
+// Only new arrivals are considered unless after (an email ID) or since (RFC 3339) is given,
+// in which case matching emails already in the inbox are returned right away.
+func (s *Server) waitEmail(c echo.Context) error {
+	accountID := c.Param("id")
+
+	exists, err := s.db.AccountExists(accountID)
+	if err != nil || !exists {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Account not found",
+		})
+	}
+
+	timeout, err := parseWaitTimeout(c.QueryParam("timeout"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid timeout",
+		})
+	}
+
+	filter := waitFilter{
+		subject: c.QueryParam("subject"),
+		from:    c.QueryParam("from"),
+		to:      c.QueryParam("to"),
+		tag:     c.QueryParam("tag"),
+	}
+	if after := c.QueryParam("after"); after != "" {
+		if filter.after, err = strconv.ParseInt(after, 10, 64); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid after email ID",
+			})
+		}
+		filter.existing = true
+	}
+	if since := c.QueryParam("since"); since != "" {
+		if filter.since, err = time.Parse(time.RFC3339, since); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid since time, expected RFC 3339",
+			})
+		}
+		filter.existing = true
+	}
+
+	// Subscribe before looking at stored emails so nothing slips through in between
+	sub := event.Subscribe(accountID)
+	defer sub.Close()
+
+	if filter.existing {
+		stored := db.EmailFilter{
+			Tag:     filter.tag,
+			From:    filter.from,
+			To:      filter.to,
+			Subject: filter.subject,
+			AfterID: filter.after,
+		}
+		if !filter.since.IsZero() {
+			stored.Since = &filter.since
+		}
+
+		// Answer with the oldest match
+		list, err := s.db.ListEmails(accountID, stored, db.EmailPage{Limit: 1, Ascending: true})
+		if err != nil {
+			log.Error("Failed to get emails for account %s: %v", accountID, err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to fetch emails",
+			})
+		}
+		if len(list.Emails) > 0 {
+			return s.respondEmail(c, list.Emails[0].ID, accountID)
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-s.ctx.Done():
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{
+				"error": "Server is shutting down",
+			})
+		case <-timer.C:
+			return c.JSON(http.StatusRequestTimeout, map[string]string{
+				"error": "Timed out waiting for email",
+			})
+		case e, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+			if e.Type == event.EmailReceived && e.Email != nil && filter.matches(e.Email) {
+				return s.respondEmail(c, e.Email.ID, accountID)
+			}
+		}
+	}
+}
+
+// respondEmail writes the full email in the same shape as getEmail
+func (s *Server) respondEmail(c echo.Context, emailID int64, accountID string) error {
+	email, err := s.db.GetEmail(emailID, accountID)
+	if err != nil {
+		log.Error("Failed to get email %d for account %s: %v", emailID, accountID, err)
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Email not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"email": email,
+	})
+}
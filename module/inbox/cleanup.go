@@ -25,21 +25,13 @@ func (c *Cleanup) Start(ctx context.Context) error {
 		interval = 5 * time.Minute
 	}
 
-	age := c.cfg.Inbox.MaxAge
-	if age <= 0 {
-		age = 24 * time.Hour
-	}
-
 	go func() {
 		for {
 			select {
 			case <-c.ctx.Done():
 				return
 			case <-time.After(interval):
-				log.Info("Cleaning up inbox with age %vs", age)
-				if err := c.db.Cleanup(int(age.Hours())); err != nil {
-					log.Error("Failed to cleanup inbox: %v", err)
-				}
+				c.purge()
 			}
 		}
 	}()
@@ -47,6 +39,54 @@ func (c *Cleanup) Start(ctx context.Context) error {
 	return nil
 }
 
+// purge applies the account and email retention of every domain, then removes orphaned rows
+func (c *Cleanup) purge() {
+	var report db.PurgeReport
+	now := time.Now()
+
+	purged, err := c.db.PurgeExpiredAccounts(now)
+	if err != nil {
+		log.Error("Failed to clean up expired accounts: %v", err)
+	}
+	report.Add(purged)
+
+	for i, domain := range c.cfg.Domains() {
+		// Accounts without an expiry live for the lifetime of their domain
+		age := domain.AccountTTL()
+
+		// Accounts created before domains were recorded belong to the default domain
+		domains := []string{domain.Name}
+		if i == 0 {
+			domains = append(domains, "")
+		}
+
+		log.Debug("Cleaning up accounts of %s older than %v", domain.Name, age)
+		purged, err := c.db.PurgeAccounts(now.Add(-age), domains...)
+		if err != nil {
+			log.Error("Failed to clean up accounts of %s: %v", domain.Name, err)
+		}
+		report.Add(purged)
+
+		if domain.EmailMaxAge > 0 {
+			log.Debug("Cleaning up emails of %s older than %v", domain.Name, domain.EmailMaxAge)
+			purged, err := c.db.PurgeEmails(now.Add(-domain.EmailMaxAge), domains...)
+			if err != nil {
+				log.Error("Failed to clean up emails of %s: %v", domain.Name, err)
+			}
+			report.Add(purged)
+		}
+	}
+
+	purged, err = c.db.PurgeOrphans()
+	if err != nil {
+		log.Error("Failed to clean up orphaned emails: %v", err)
+	}
+	report.Add(purged)
+
+	log.Info("Cleanup purged %d rows: %d accounts, %d emails, %d headers, %d attachments, %d raw messages, %d labels, %d extractions",
+		report.Total(), report.Accounts, report.Emails, report.Headers, report.Attachments, report.RawMessages, report.Labels, report.Extractions)
+}
+
 func (c *Cleanup) Close() error {
 	c.cancel()
 	return nil
@@ -0,0 +1,41 @@
+package smtp
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/galihrivanto/kotak/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticator(t *testing.T) {
+	conns := newConnections(nil)
+	client, server := net.Pipe()
+	defer client.Close()
+	conns.open(server)
+	addr := server.RemoteAddr()
+
+	auth := newAuthenticator([]config.Credential{
+		{Username: "app", Password: "secret", Domains: []string{"ci.example.com"}},
+	}, conns)
+
+	ok, err := auth.handleAuth(addr, "PLAIN", []byte("app"), []byte("wrong"), nil)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Empty(t, conns.get(addr).AuthUser())
+
+	shared := []byte("<1.2@kotak.local>")
+	mac := hmac.New(md5.New, []byte("secret"))
+	mac.Write(shared)
+	ok, err = auth.handleAuth(addr, "CRAM-MD5", []byte("app"), []byte(hex.EncodeToString(mac.Sum(nil))), shared)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "app", conns.get(addr).AuthUser())
+
+	assert.True(t, auth.allowsDomain("app", "CI.example.com"))
+	assert.False(t, auth.allowsDomain("app", "example.com"))
+}
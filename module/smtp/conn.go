@@ -0,0 +1,147 @@
+package smtp
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+// connState is what is known about an SMTP connection beyond what smtpd passes to the handlers
+type connState struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	tls      *tls.ConnectionState
+	authUser string
+}
+
+// Drop closes the connection without a reply
+func (c *connState) Drop() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+}
+
+// TLS returns the negotiated TLS state, nil when the connection is in plain text
+func (c *connState) TLS() *tls.ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tls
+}
+
+func (c *connState) setTLS(state tls.ConnectionState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tls = &state
+}
+
+// AuthUser returns the identity the client authenticated as, empty when it has not
+func (c *connState) AuthUser() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.authUser
+}
+
+func (c *connState) setAuthUser(username string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.authUser = username
+}
+
+// connections tracks open connections by remote address, which is all the smtpd handlers receive
+type connections struct {
+	mu    sync.Mutex
+	conns map[string]*connState
+
+	// limits caps concurrent connections, nil when unlimited
+	limits *limiter
+}
+
+func newConnections(limits *limiter) *connections {
+	return &connections{conns: map[string]*connState{}, limits: limits}
+}
+
+// get returns the state of an open connection, or an empty state when it is not tracked
+func (c *connections) get(addr net.Addr) *connState {
+	if addr == nil {
+		return &connState{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state, ok := c.conns[addr.String()]; ok {
+		return state
+	}
+	return &connState{}
+}
+
+func (c *connections) open(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conns[conn.RemoteAddr().String()] = &connState{conn: conn}
+}
+
+func (c *connections) close(addr net.Addr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, addr.String())
+}
+
+// listen wraps a listener so accepted connections are tracked until they are closed.
+// Connections over the limit are turned away, with a 421 greeting unless TLS is implicit.
+func (c *connections) listen(ln net.Listener, implicitTLS bool) net.Listener {
+	return &trackingListener{Listener: ln, conns: c, implicitTLS: implicitTLS}
+}
+
+// rejectWriteTimeout bounds how long the 421 reply to a connection over the limit may take
+const rejectWriteTimeout = time.Second
+
+type trackingListener struct {
+	net.Listener
+	conns       *connections
+	implicitTLS bool
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := remoteIP(conn.RemoteAddr())
+		if l.conns.limits != nil && !l.conns.limits.acquire(ip) {
+			// Turn the client away in the background, a slow reader must not hold up Accept
+			go func() {
+				if !l.implicitTLS {
+					// Best effort, the client is turned away either way
+					_ = conn.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+					_, _ = conn.Write([]byte(replyTooManyConnections + "\r\n"))
+				}
+				_ = conn.Close()
+			}()
+			continue
+		}
+
+		tracked := &trackedConn{Conn: conn, conns: l.conns, ip: ip}
+		l.conns.open(tracked)
+		return tracked, nil
+	}
+}
+
+type trackedConn struct {
+	net.Conn
+	conns *connections
+	ip    string
+	once  sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.conns.close(c.RemoteAddr())
+		if c.conns.limits != nil {
+			c.conns.limits.release(c.ip)
+		}
+	})
+	return c.Conn.Close()
+}
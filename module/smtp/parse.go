@@ -0,0 +1,249 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/galihrivanto/kotak/extract"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// maxPartDepth limits how deep nested multipart bodies are walked
+const maxPartDepth = 10
+
+// Header is a single decoded message header, kept in original order
+type Header struct {
+	Name  string
+	Value string
+}
+
+// Attachment is a non-body MIME part of a message
+type Attachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string
+	Inline      bool
+	Data        []byte
+}
+
+// Message is the parsed form of an incoming email
+type Message struct {
+	Headers     []Header
+	From        string
+	To          string
+	Cc          string
+	Subject     string
+	MessageID   string
+	Date        time.Time
+	Text        string
+	HTML        string
+	Attachments []Attachment
+}
+
+// Header returns the first value of the named header
+func (m *Message) Header(name string) string {
+	for _, h := range m.Headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// Snippet returns the start of the message body as a single line of text
+func (m *Message) Snippet() string {
+	return extract.Snippet(m.Text, m.HTML)
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// parseMessage parses raw message data into headers, body parts and attachments
+func parseMessage(data []byte) (*Message, error) {
+	br := bufio.NewReader(bytes.NewReader(data))
+
+	rawHeaders, err := readHeaders(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read headers: %w", err)
+	}
+
+	msg := &Message{}
+	for _, h := range rawHeaders {
+		msg.Headers = append(msg.Headers, Header{Name: h.Name, Value: decodeHeader(h.Value)})
+	}
+
+	msg.From = msg.Header("From")
+	msg.To = msg.Header("To")
+	msg.Cc = msg.Header("Cc")
+	msg.Subject = msg.Header("Subject")
+	msg.MessageID = strings.Trim(msg.Header("Message-Id"), "<> ")
+	if date, err := mail.ParseDate(msg.Header("Date")); err == nil {
+		msg.Date = date
+	}
+
+	header := map[string]string{}
+	for _, h := range rawHeaders {
+		key := strings.ToLower(h.Name)
+		if _, ok := header[key]; !ok {
+			header[key] = h.Value
+		}
+	}
+
+	if err := msg.walk(header, br, 0); err != nil {
+		return msg, fmt.Errorf("failed to parse body: %w", err)
+	}
+
+	return msg, nil
+}
+
+// walk descends into a MIME entity and collects its body parts and attachments
+func (m *Message) walk(header map[string]string, body io.Reader, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(header["content-type"])
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && depth < maxPartDepth {
+		boundary := params["boundary"]
+		if boundary == "" {
+			return fmt.Errorf("multipart body without boundary")
+		}
+
+		mr := multipart.NewReader(body, boundary)
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			partHeader := map[string]string{}
+			for key, values := range part.Header {
+				partHeader[strings.ToLower(key)] = values[0]
+			}
+
+			if err := m.walk(partHeader, part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := io.ReadAll(decodeTransfer(header["content-transfer-encoding"], body))
+	if err != nil {
+		return err
+	}
+
+	disposition, dispParams, _ := mime.ParseMediaType(header["content-disposition"])
+	filename := dispParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	filename = decodeHeader(filename)
+
+	isAttachment := disposition == "attachment" || filename != ""
+	if !isAttachment && mediaType == "text/plain" && m.Text == "" {
+		m.Text = decodeCharset(params["charset"], content)
+		return nil
+	}
+	if !isAttachment && mediaType == "text/html" && m.HTML == "" {
+		m.HTML = decodeCharset(params["charset"], content)
+		return nil
+	}
+
+	m.Attachments = append(m.Attachments, Attachment{
+		Filename:    filename,
+		ContentType: mediaType,
+		ContentID:   strings.Trim(header["content-id"], "<> "),
+		Inline:      disposition == "inline",
+		Data:        content,
+	})
+
+	return nil
+}
+
+// readHeaders reads the header block, unfolding continuation lines
+func readHeaders(br *bufio.Reader) ([]Header, error) {
+	var headers []Header
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		trimmed := strings.TrimRight(line, "\r\n")
+		if trimmed == "" {
+			return headers, nil
+		}
+
+		if (trimmed[0] == ' ' || trimmed[0] == '\t') && len(headers) > 0 {
+			headers[len(headers)-1].Value += " " + strings.TrimSpace(trimmed)
+		} else if name, value, ok := strings.Cut(trimmed, ":"); ok {
+			headers = append(headers, Header{
+				Name:  strings.TrimSpace(name),
+				Value: strings.TrimSpace(value),
+			})
+		}
+
+		if err == io.EOF {
+			return headers, nil
+		}
+	}
+}
+
+// decodeHeader decodes RFC 2047 encoded words, returning the input on failure
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// decodeTransfer wraps r with a decoder for the given Content-Transfer-Encoding
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+// decodeCharset converts content in the given charset to UTF-8
+func decodeCharset(charset string, content []byte) string {
+	r, err := charsetReader(charset, bytes.NewReader(content))
+	if err != nil {
+		return string(content)
+	}
+
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return string(content)
+	}
+	return string(decoded)
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if charset == "" || charset == "utf-8" || charset == "us-ascii" {
+		return input, nil
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
@@ -0,0 +1,111 @@
+package smtp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMessage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		raw      string
+		subject  string
+		text     string
+		html     string
+		attached []string
+	}{
+		{
+			name: "plain text",
+			raw: "From: sender@example.com\r\n" +
+				"Subject: Hello\r\n" +
+				"\r\n" +
+				"Hello world\r\n",
+			subject: "Hello",
+			text:    "Hello world\r\n",
+		},
+		{
+			name: "folded encoded subject with quoted-printable body",
+			raw: "From: sender@example.com\r\n" +
+				"Subject: =?UTF-8?B?SGFsbw==?=\r\n" +
+				" =?ISO-8859-1?Q?D=FCnya?=\r\n" +
+				"Content-Type: text/plain; charset=utf-8\r\n" +
+				"Content-Transfer-Encoding: quoted-printable\r\n" +
+				"\r\n" +
+				"Caf=C3=A9 soft=\r\nbreak\r\n",
+			subject: "HaloDünya",
+			text:    "Café softbreak\r\n",
+		},
+		{
+			name: "multipart with alternative and attachment",
+			raw: "From: sender@example.com\r\n" +
+				"Subject: Report\r\n" +
+				"Content-Type: multipart/mixed; boundary=outer\r\n" +
+				"\r\n" +
+				"--outer\r\n" +
+				"Content-Type: multipart/alternative; boundary=inner\r\n" +
+				"\r\n" +
+				"--inner\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"\r\n" +
+				"See attached\r\n" +
+				"--inner\r\n" +
+				"Content-Type: text/html\r\n" +
+				"Content-Transfer-Encoding: base64\r\n" +
+				"\r\n" +
+				"PHA+U2VlIGF0dGFjaGVkPC9wPg==\r\n" +
+				"--inner--\r\n" +
+				"--outer\r\n" +
+				"Content-Type: text/csv\r\n" +
+				"Content-Disposition: attachment; filename=\"report.csv\"\r\n" +
+				"\r\n" +
+				"a,b\r\n" +
+				"--outer--\r\n",
+			subject:  "Report",
+			text:     "See attached",
+			html:     "<p>See attached</p>",
+			attached: []string{"report.csv"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := parseMessage([]byte(tc.raw))
+			require.NoError(t, err)
+
+			assert.Equal(t, "sender@example.com", msg.From)
+			assert.Equal(t, tc.subject, msg.Subject)
+			assert.Equal(t, tc.text, msg.Text)
+			assert.Equal(t, tc.html, msg.HTML)
+
+			var filenames []string
+			for _, a := range msg.Attachments {
+				filenames = append(filenames, a.Filename)
+			}
+			assert.Equal(t, tc.attached, filenames)
+		})
+	}
+}
+
+func TestParseMessageHeaderOrder(t *testing.T) {
+	raw := "Received: first\r\nReceived: second\r\nX-Long: a\r\n\tb\r\n\r\nbody"
+
+	msg, err := parseMessage([]byte(raw))
+	require.NoError(t, err)
+
+	require.Len(t, msg.Headers, 3)
+	assert.Equal(t, "first", msg.Headers[0].Value)
+	assert.Equal(t, "second", msg.Headers[1].Value)
+	assert.Equal(t, "a b", msg.Header("x-long"))
+	assert.True(t, strings.HasPrefix(msg.Text, "body"))
+}
+
+func TestMessageSnippet(t *testing.T) {
+	msg := &Message{Text: "  Hello\r\n\r\n  world  "}
+	assert.Equal(t, "Hello world", msg.Snippet())
+
+	msg = &Message{HTML: "<html><head><title>T</title><style>p{}</style></head><body><p>Your code</p><p>is <b>1234</b></p></body></html>"}
+	assert.Equal(t, "Your code is 1234", msg.Snippet())
+}
@@ -0,0 +1,222 @@
+package smtp
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/galihrivanto/kotak/config"
+	"github.com/galihrivanto/kotak/log"
+)
+
+// Replies sent when a limit is exceeded
+const (
+	replyTooManyConnections = "421 4.7.0 Too many connections, try again later"
+	replyRateLimited        = "451 4.7.1 Rate limit exceeded, try again later"
+)
+
+// bucket is a token bucket refilled at rate tokens per minute, holding at most rate tokens
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimit limits events per key to a number per minute
+type rateLimit struct {
+	rate    int
+	buckets map[string]*bucket
+}
+
+func newRateLimit(rate int) *rateLimit {
+	return &rateLimit{rate: rate, buckets: map[string]*bucket{}}
+}
+
+// refill returns the bucket of key with its tokens brought up to date
+func (r *rateLimit) refill(key string, now time.Time) *bucket {
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(r.rate), last: now}
+		r.buckets[key] = b
+	}
+
+	b.tokens = min(float64(r.rate), b.tokens+now.Sub(b.last).Minutes()*float64(r.rate))
+	b.last = now
+	return b
+}
+
+// sweep forgets buckets that are full again, they behave like new ones
+func (r *rateLimit) sweep(now time.Time) {
+	for key, b := range r.buckets {
+		if now.Sub(b.last) >= time.Minute {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+// rateCounters are the totals of rejected connections and messages by limit
+type rateCounters struct {
+	connections atomic.Int64
+	ip          atomic.Int64
+	sender      atomic.Int64
+	recipient   atomic.Int64
+}
+
+// limiter enforces the connection and message rate limits of the SMTP server
+type limiter struct {
+	cfg config.RateLimit
+
+	mu        sync.Mutex
+	total     int
+	perIP     map[string]int
+	ip        *rateLimit
+	sender    *rateLimit
+	recipient *rateLimit
+
+	rejected rateCounters
+}
+
+func newLimiter(cfg config.RateLimit) *limiter {
+	return &limiter{
+		cfg:       cfg,
+		perIP:     map[string]int{},
+		ip:        newRateLimit(cfg.MessagesPerIP),
+		sender:    newRateLimit(cfg.MessagesPerSender),
+		recipient: newRateLimit(cfg.MessagesPerRecipient),
+	}
+}
+
+// acquire reserves a connection slot for the client IP, reporting false when a limit is reached
+func (l *limiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if (l.cfg.MaxConnections > 0 && l.total >= l.cfg.MaxConnections) ||
+		(l.cfg.MaxConnectionsPerIP > 0 && l.perIP[ip] >= l.cfg.MaxConnectionsPerIP) {
+		n := l.rejected.connections.Add(1)
+		log.Warn("Rejected connection from %s: too many connections (%d rejected so far)", ip, n)
+		return false
+	}
+
+	l.total++
+	l.perIP[ip]++
+	return true
+}
+
+// release frees the connection slot of the client IP
+func (l *limiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// allowSender reports whether the client IP and the sender have a message left, without taking it,
+// so exhausted senders are turned away at MAIL FROM rather than after DATA
+func (l *limiter) allowSender(ip, sender string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := l.check(l.ip, ip, now, ip, "client IP", &l.rejected.ip); !ok {
+		return false
+	}
+	_, ok := l.check(l.sender, strings.ToLower(sender), now, ip, "sender", &l.rejected.sender)
+	return ok
+}
+
+// allowRecipient reports whether the recipient has a message left, without taking it,
+// so exhausted recipients are turned away at RCPT TO
+func (l *limiter) allowRecipient(ip, recipient string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.check(l.recipient, recipient, time.Now(), ip, "recipient", &l.rejected.recipient)
+	return ok
+}
+
+// allowMessage takes a token for the client IP, the sender and every recipient,
+// taking none when any of them is exhausted
+func (l *limiter) allowMessage(ip, sender string, recipients []string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var taken []*bucket
+	take := func(limit *rateLimit, key string, name string, counter *atomic.Int64) bool {
+		b, ok := l.check(limit, key, now, ip, name, counter)
+		if b != nil {
+			taken = append(taken, b)
+		}
+		return ok
+	}
+
+	if !take(l.ip, ip, "client IP", &l.rejected.ip) {
+		return false
+	}
+	if !take(l.sender, strings.ToLower(sender), "sender", &l.rejected.sender) {
+		return false
+	}
+	for _, recipient := range recipients {
+		if !take(l.recipient, recipient, "recipient", &l.rejected.recipient) {
+			return false
+		}
+	}
+
+	for _, b := range taken {
+		b.tokens--
+	}
+	return true
+}
+
+// check returns the bucket of key and whether it has a token left, counting and logging the
+// rejection when it has not. The bucket is nil when the limit is disabled.
+func (l *limiter) check(limit *rateLimit, key string, now time.Time, ip, name string, counter *atomic.Int64) (*bucket, bool) {
+	if limit.rate <= 0 || key == "" {
+		return nil, true
+	}
+	b := limit.refill(key, now)
+	if b.tokens < 1 {
+		n := counter.Add(1)
+		log.Warn("Rate limited message from %s: %s limit exceeded for %s (%d rejected so far)", ip, name, key, n)
+		return b, false
+	}
+	return b, true
+}
+
+// run periodically drops idle buckets and logs the rejection counters when they change
+func (l *limiter) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	var last [4]int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			l.ip.sweep(now)
+			l.sender.sweep(now)
+			l.recipient.sweep(now)
+			connections := l.total
+			l.mu.Unlock()
+
+			current := [4]int64{
+				l.rejected.connections.Load(),
+				l.rejected.ip.Load(),
+				l.rejected.sender.Load(),
+				l.rejected.recipient.Load(),
+			}
+			if current != last {
+				log.Info("Rate limit counters: open connections=%d rejected connections=%d ip=%d sender=%d recipient=%d",
+					connections, current[0], current[1], current[2], current[3])
+				last = current
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package smtp
+
+import (
+	"testing"
+
+	"github.com/galihrivanto/kotak/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterMessages(t *testing.T) {
+	l := newLimiter(config.RateLimit{MessagesPerSender: 2, MessagesPerRecipient: 3})
+
+	assert.True(t, l.allowMessage("10.0.0.1", "a@example.com", []string{"x@kotak.local"}))
+	assert.True(t, l.allowMessage("10.0.0.1", "A@example.com", []string{"x@kotak.local"}))
+	assert.False(t, l.allowMessage("10.0.0.1", "a@example.com", []string{"y@kotak.local"}))
+
+	// A rejected message does not use up the recipient limit
+	assert.True(t, l.allowMessage("10.0.0.2", "b@example.com", []string{"x@kotak.local"}))
+	assert.False(t, l.allowMessage("10.0.0.2", "c@example.com", []string{"x@kotak.local"}))
+	assert.Equal(t, int64(1), l.rejected.sender.Load())
+	assert.Equal(t, int64(1), l.rejected.recipient.Load())
+}
+
+func TestLimiterEarlyChecks(t *testing.T) {
+	l := newLimiter(config.RateLimit{MessagesPerSender: 1, MessagesPerRecipient: 1})
+
+	// Checks at MAIL FROM and RCPT TO take no token
+	assert.True(t, l.allowSender("10.0.0.1", "a@example.com"))
+	assert.True(t, l.allowRecipient("10.0.0.1", "x@kotak.local"))
+	assert.True(t, l.allowSender("10.0.0.1", "a@example.com"))
+
+	assert.True(t, l.allowMessage("10.0.0.1", "a@example.com", []string{"x@kotak.local"}))
+	assert.False(t, l.allowSender("10.0.0.1", "A@example.com"))
+	assert.False(t, l.allowRecipient("10.0.0.2", "x@kotak.local"))
+	assert.True(t, l.allowSender("10.0.0.2", "b@example.com"))
+	assert.True(t, l.allowRecipient("10.0.0.2", "y@kotak.local"))
+}
+
+func TestLimiterConnections(t *testing.T) {
+	l := newLimiter(config.RateLimit{MaxConnectionsPerIP: 1, MaxConnections: 2})
+
+	assert.True(t, l.acquire("10.0.0.1"))
+	assert.False(t, l.acquire("10.0.0.1"))
+	assert.True(t, l.acquire("10.0.0.2"))
+	assert.False(t, l.acquire("10.0.0.3"))
+
+	l.release("10.0.0.1")
+	assert.True(t, l.acquire("10.0.0.1"))
+}
@@ -1,17 +1,27 @@
 package smtp
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
+	"regexp"
 	"strings"
+	"time"
 
+	"github.com/galihrivanto/kotak/account"
 	"github.com/galihrivanto/kotak/config"
 	"github.com/galihrivanto/kotak/db"
+	"github.com/galihrivanto/kotak/event"
+	"github.com/galihrivanto/kotak/extract"
+	"github.com/galihrivanto/kotak/fault"
 	"github.com/galihrivanto/kotak/log"
 	"github.com/galihrivanto/kotak/module"
-
-	"github.com/mhale/smtpd"
+	"github.com/galihrivanto/kotak/third_party/smtpd"
 )
 
 type Server struct {
@@ -21,32 +31,77 @@ type Server struct {
 	db     *db.DB
 
 	srv *smtpd.Server
+
+	// conns holds per-connection state such as the negotiated TLS parameters
+	conns *connections
+	// auth checks SMTP AUTH credentials, nil when authentication is disabled
+	auth *authenticator
+	// faults injects failures, changed at runtime through the admin API
+	faults *fault.Injector
+	// limits enforces the rate limits
+	limits *limiter
+
+	// domains are the accepted receiving domains, the first one is the default
+	domains []*domainPolicy
+}
+
+// domainPolicy is an accepted domain with its compiled catch-all pattern
+type domainPolicy struct {
+	config.Domain
+
+	// catchAllPattern restricts auto-provisioned accounts, nil allows any valid name
+	catchAllPattern *regexp.Regexp
 }
 
 func (s *Server) Start(ctx context.Context) error {
 	s.ctx, s.cancel = context.WithCancel(ctx)
 
-	// Start the server
-	go func() {
-		addr := fmt.Sprintf("%s:%s", s.config.SmtpServer.Host, s.config.SmtpServer.Port)
-		ln, err := net.Listen("tcp", addr)
+	cfg := s.config.SmtpServer
+	if cfg.TLS {
+		tlsConfig, err := newTLSConfig(cfg, s.conns)
 		if err != nil {
-			log.Error("SMTP server listen error: %v", err)
-
-			return
+			return err
 		}
+		s.srv.TLSConfig = tlsConfig
+		s.srv.TLSRequired = cfg.TLSRequired
+	}
 
-		log.Info("SMTP server listening on %s", addr)
+	go s.limits.run(s.ctx)
 
-		err = s.srv.Serve(ln)
-		if err != nil {
-			log.Error("SMTP server error: %v", err)
-		}
-	}()
+	// Start the server
+	go s.serve(fmt.Sprintf("%s:%s", cfg.Host, cfg.Port), false)
+
+	// Implicit TLS listener, clients start the handshake right after connecting
+	if s.srv.TLSConfig != nil && cfg.TLSPort != "" {
+		go s.serve(fmt.Sprintf("%s:%s", cfg.Host, cfg.TLSPort), true)
+	}
 
 	return nil
 }
 
+// serve accepts connections on addr, wrapping them in TLS before the greeting when implicit is set
+func (s *Server) serve(addr string, implicitTLS bool) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Error("SMTP server listen error: %v", err)
+
+		return
+	}
+
+	ln = s.conns.listen(ln, implicitTLS)
+	if implicitTLS {
+		ln = tls.NewListener(ln, s.srv.TLSConfig)
+		log.Info("SMTP server listening on %s (implicit TLS)", addr)
+	} else {
+		log.Info("SMTP server listening on %s", addr)
+	}
+
+	err = s.srv.Serve(ln)
+	if err != nil {
+		log.Error("SMTP server error: %v", err)
+	}
+}
+
 func (s *Server) Close() error {
 	log.Info("Stopping SMTP server")
 	// stop server
@@ -56,64 +111,558 @@ func (s *Server) Close() error {
 }
 
 // handleMail processes incoming emails
-func (s *Server) handleMail(_ net.Addr, from string, to []string, data []byte) error {
+func (s *Server) handleMail(remoteAddr net.Addr, from string, to []string, data []byte) error {
 	log.Info("Received mail from %s to %v", from, to)
 
-	// Extract email components
-	body := string(data)
-	subject := extractHeader(body, "Subject:")
+	if outcome := s.faults.Deliver(to); outcome != nil {
+		if outcome.Drop {
+			log.Info("Dropping connection from %s (fault injection)", remoteIP(remoteAddr))
+			s.conns.get(remoteAddr).Drop()
+			return errConnectionDropped
+		}
+		log.Info("Rejecting mail from %s with %q (fault injection)", from, outcome.Reply)
+		return errors.New(outcome.Reply)
+	}
+
+	if !s.limits.allowMessage(remoteIP(remoteAddr), from, s.recipientKeys(to)) {
+		return errors.New(replyRateLimited)
+	}
+
+	// Parse the message, falling back to the raw data as plain text
+	msg, err := parseMessage(data)
+	if err != nil {
+		log.Warn("Failed to parse mail from %s: %v", from, err)
+		if msg == nil {
+			msg = &Message{Text: string(data)}
+		}
+	}
+
+	envelope := db.Envelope{
+		MailFrom: from,
+		RcptTo:   strings.Join(to, ", "),
+		ClientIP: remoteIP(remoteAddr),
+		Helo:     heloName(data),
+	}
+	conn := s.conns.get(remoteAddr)
+	tlsEnvelope(&envelope, conn.TLS())
+	envelope.AuthUser = conn.AuthUser()
+
+	// Enforce the size limit of every recipient domain before storing anything
+	for _, recipient := range to {
+		_, domain, _ := splitAddress(recipient)
+		if policy := s.domain(domain); policy != nil && policy.MaxSize > 0 && len(data) > policy.MaxSize {
+			return fmt.Errorf("552 5.3.4 Message size exceeds fixed maximum message size for %s (%d)", policy.Name, policy.MaxSize)
+		}
+	}
 
-	// Process each recipient
+	// Resolve every recipient before storing anything, a full mailbox rejects the whole message
+	var deliveries []delivery
 	for _, recipient := range to {
 		// Extract account ID from email address
-		parts := strings.Split(recipient, "@")
-		if len(parts) != 2 {
+		localPart, domain, ok := splitAddress(recipient)
+		if !ok {
 			continue // Invalid email format
 		}
 
-		accountID := parts[0]
+		policy := s.domain(domain)
+		if policy == nil {
+			log.Error("Domain %s is not accepted, skipping email", domain)
+			continue
+		}
 
-		// Check if account exists
-		exists, err := s.db.AccountExists(accountID)
-		if err != nil || !exists {
-			log.Error("Account %s not found, skipping email", accountID)
+		accountID, tag, err := s.resolveAccount(localPart, policy)
+		if err != nil {
+			log.Error("No account for %s, skipping email: %v", recipient, err)
 			continue
 		}
 
+		deliveries = append(deliveries, delivery{recipient: recipient, accountID: accountID, tag: tag})
+	}
+
+	if err := s.enforceQuota(deliveries, int64(len(data))); err != nil {
+		return err
+	}
+
+	for _, d := range deliveries {
 		// Store the email
-		_, err = s.db.StoreEmail(accountID, from, recipient, subject, body)
+		email := newEmail(msg, d.accountID, from, d.recipient)
+		email.Tag = d.tag
+		email.Envelope = envelope
+		email.Size = int64(len(data))
+		email.Raw = &db.RawMessage{Data: data}
+
+		_, err = s.db.StoreEmail(email)
 		if err != nil {
 			log.Error("Failed to store email: %v", err)
 		} else {
-			log.Info("Stored email for account %s", accountID)
+			log.Info("Stored email for account %s", d.accountID)
+			event.Publish(event.Event{Type: event.EmailReceived, AccountID: d.accountID, Email: email})
+		}
+	}
+
+	return nil
+}
+
+// delivery is a recipient resolved to its account
+type delivery struct {
+	recipient string
+	accountID string
+	tag       string
+}
+
+// enforceQuota makes room for a message of the given size in every recipient account,
+// evicting the oldest emails or rejecting the message with 552 depending on the quota policy
+func (s *Server) enforceQuota(deliveries []delivery, size int64) error {
+	inbox := s.config.Inbox
+	if inbox.MaxMessages <= 0 && inbox.MaxStorage <= 0 {
+		return nil
+	}
+
+	checked := map[string]bool{}
+	for _, d := range deliveries {
+		if checked[d.accountID] {
+			continue
+		}
+		checked[d.accountID] = true
+
+		if inbox.MaxStorage > 0 && size > inbox.MaxStorage {
+			return fmt.Errorf("552 5.3.4 Message exceeds the mailbox storage quota of %s", d.recipient)
+		}
+
+		if inbox.QuotaPolicy == config.QuotaEvict {
+			evicted, err := s.db.EvictEmails(d.accountID, inbox.MaxMessages, inbox.MaxStorage, size)
+			if err != nil {
+				log.Error("Failed to evict emails of account %s: %v", d.accountID, err)
+				return fmt.Errorf("451 4.3.0 Unable to make room in mailbox %s", d.recipient)
+			}
+			if evicted > 0 {
+				log.Info("Evicted %d emails of account %s to stay within quota", evicted, d.accountID)
+			}
+			continue
+		}
+
+		usage, err := s.db.GetUsage(d.accountID)
+		if err != nil {
+			log.Error("Failed to get usage of account %s: %v", d.accountID, err)
+			return fmt.Errorf("451 4.3.0 Unable to check quota of mailbox %s", d.recipient)
+		}
+		if (inbox.MaxMessages > 0 && usage.Messages+1 > inbox.MaxMessages) ||
+			(inbox.MaxStorage > 0 && usage.Bytes+size > inbox.MaxStorage) {
+			log.Info("Rejected mail for %s: mailbox full", d.recipient)
+			return fmt.Errorf("552 5.2.2 Mailbox %s is full", d.recipient)
+		}
+	}
+	return nil
+}
+
+// resolveAccount maps the local part of a recipient to an account and sub-address tag,
+// provisioning a new account on first delivery when catch-all is enabled for the domain
+func (s *Server) resolveAccount(localPart string, policy *domainPolicy) (string, string, error) {
+	id, tag, ok, err := s.lookupAccount(localPart, policy)
+	if err != nil {
+		return "", "", err
+	}
+	if ok {
+		return id, tag, nil
+	}
+
+	name, tag, err := s.catchAllName(localPart, policy)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Auto-provisioned accounts have no token, their inbox is open to anyone who knows the address
+	expiresAt := time.Now().Add(policy.AccountTTL())
+	err = s.db.CreateAccount(&db.Account{ID: name, Domain: policy.Name, ExpiresAt: &expiresAt})
+	if errors.Is(err, db.ErrAccountExists) {
+		// Created concurrently or taken on another domain
+		ok, err = s.accountOnDomain(name, policy)
+		if err != nil {
+			return "", "", err
+		}
+		if !ok {
+			return "", "", errAccountNotFound
+		}
+		return name, tag, nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	log.Info("Provisioned catch-all account %s@%s", name, policy.Name)
+	return name, tag, nil
+}
+
+// lookupAccount finds the existing account of a local part, directly or through its sub-address base.
+// Account names are stored in lower case, the tag keeps the case it was sent with.
+func (s *Server) lookupAccount(localPart string, policy *domainPolicy) (string, string, bool, error) {
+	id := strings.ToLower(localPart)
+	ok, err := s.accountOnDomain(id, policy)
+	if err != nil || ok {
+		return id, "", ok, err
+	}
+	if _, ok := s.catchAllWhole(localPart, policy); ok {
+		// Provisioned as an account of its own rather than delivered to a shorter account
+		return "", "", false, nil
+	}
+
+	// Account names may contain delimiters themselves, e.g. brave-otter-42, so the longest existing base wins
+	for _, split := range s.subAddressSplits(localPart) {
+		id = strings.ToLower(split[0])
+		ok, err = s.accountOnDomain(id, policy)
+		if err != nil {
+			return "", "", false, err
+		}
+		if ok {
+			return id, split[1], true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// catchAllName returns the account name and sub-address tag catch-all would provision for a local part
+func (s *Server) catchAllName(localPart string, policy *domainPolicy) (string, string, error) {
+	if !policy.CatchAllEnabled() {
+		return "", "", errAccountNotFound
+	}
+	if name, ok := s.catchAllWhole(localPart, policy); ok {
+		return name, "", nil
+	}
+
+	base, tag := s.splitSubAddress(localPart)
+	name, err := account.NormalizeName(base, s.config.Account.ReservedNames)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot provision account: %w", err)
+	}
+	if policy.catchAllPattern != nil && !policy.catchAllPattern.MatchString(name) {
+		return "", "", fmt.Errorf("cannot provision account: %s does not match catch-all pattern", name)
+	}
+	return name, tag, nil
+}
+
+// catchAllWhole returns the account name of a local part the catch-all pattern matches as a whole,
+// e.g. build-1234 for ^build-[0-9]+$, which is not split at its delimiters
+func (s *Server) catchAllWhole(localPart string, policy *domainPolicy) (string, bool) {
+	if !policy.CatchAllEnabled() || policy.catchAllPattern == nil {
+		return "", false
+	}
+	name, err := account.NormalizeName(localPart, s.config.Account.ReservedNames)
+	if err != nil || !policy.catchAllPattern.MatchString(name) {
+		return "", false
+	}
+	return name, true
+}
+
+// accountOnDomain reports whether the account exists and receives mail for the domain.
+// Accounts created before domains were recorded receive mail for every accepted domain.
+func (s *Server) accountOnDomain(id string, policy *domainPolicy) (bool, error) {
+	acc, err := s.db.GetAccount(id)
+	if errors.Is(err, db.ErrAccountNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return acc.Domain == "" || strings.EqualFold(acc.Domain, policy.Name), nil
+}
+
+// domain returns the policy of an accepted domain, or nil when the domain is not accepted
+func (s *Server) domain(name string) *domainPolicy {
+	for _, d := range s.domains {
+		if strings.EqualFold(d.Name, name) {
+			return d
+		}
+	}
+	return nil
+}
+
+// handleMailFrom turns away senders, and clients, that exhausted their message rate limit
+func (s *Server) handleMailFrom(remoteAddr net.Addr, from string) error {
+	if !s.limits.allowSender(remoteIP(remoteAddr), from) {
+		return errors.New(replyRateLimited)
+	}
+	return nil
+}
+
+// handleRcpt answers RCPT TO, with the reply of a matching fault rule or by rejecting unknown recipients
+func (s *Server) handleRcpt(remoteAddr net.Addr, _ string, to string) error {
+	if outcome := s.faults.Recipient(to); outcome != nil {
+		if outcome.Drop {
+			log.Info("Dropping connection from %s (fault injection)", remoteIP(remoteAddr))
+			s.conns.get(remoteAddr).Drop()
+			return errConnectionDropped
 		}
+		log.Info("Rejecting recipient %s with %q (fault injection)", to, outcome.Reply)
+		return errors.New(outcome.Reply)
 	}
 
+	if !s.acceptsRecipient(remoteAddr, to) {
+		return errRecipientRejected
+	}
+	for _, key := range s.recipientKeys([]string{to}) {
+		if !s.limits.allowRecipient(remoteIP(remoteAddr), key) {
+			return errors.New(replyRateLimited)
+		}
+	}
 	return nil
 }
 
-// extractHeader extracts a header value from email data
-func extractHeader(body, header string) string {
-	lines := strings.Split(body, "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, header) {
-			return strings.TrimSpace(strings.TrimPrefix(line, header))
+// acceptsRecipient reports whether the recipient has a mailbox, or would get one through catch-all,
+// so senders learn about unknown mailboxes before uploading the message
+func (s *Server) acceptsRecipient(remoteAddr net.Addr, to string) bool {
+	localPart, domain, ok := splitAddress(to)
+	if !ok {
+		log.Info("Rejected recipient %s: invalid address", to)
+		return false
+	}
+
+	policy := s.domain(domain)
+	if policy == nil {
+		log.Info("Rejected recipient %s: domain not accepted", to)
+		return false
+	}
+
+	if s.auth != nil {
+		if user := s.conns.get(remoteAddr).AuthUser(); user != "" && !s.auth.allowsDomain(user, policy.Name) {
+			log.Info("Rejected recipient %s: %s may not send to %s", to, user, policy.Name)
+			return false
+		}
+	}
+
+	_, _, ok, err := s.lookupAccount(localPart, policy)
+	if err != nil {
+		// Accept on lookup failure, the recipient is resolved again when the message is stored
+		log.Error("Failed to look up recipient %s: %v", to, err)
+		return true
+	}
+	if ok {
+		return true
+	}
+
+	name, _, err := s.catchAllName(localPart, policy)
+	if err != nil {
+		log.Info("Rejected recipient %s: %v", to, err)
+		return false
+	}
+
+	// The name may already belong to an account on another domain
+	exists, err := s.db.AccountExists(name)
+	if err != nil {
+		log.Error("Failed to look up recipient %s: %v", to, err)
+		return true
+	}
+	if exists {
+		log.Info("Rejected recipient %s: %s is taken on another domain", to, name)
+		return false
+	}
+	return true
+}
+
+// recipientKeys returns the distinct mailboxes of the recipients, ignoring sub-address tags
+func (s *Server) recipientKeys(to []string) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, recipient := range to {
+		localPart, domain, ok := splitAddress(recipient)
+		if !ok {
+			continue
+		}
+		base, _ := s.splitSubAddress(localPart)
+		if policy := s.domain(domain); policy != nil {
+			if name, ok := s.catchAllWhole(localPart, policy); ok {
+				base = name
+			}
+		}
+		key := strings.ToLower(base + "@" + domain)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// splitAddress splits an address into local part and domain
+func splitAddress(address string) (string, string, bool) {
+	i := strings.LastIndex(address, "@")
+	if i <= 0 || i == len(address)-1 {
+		return "", "", false
+	}
+	return address[:i], address[i+1:], true
+}
+
+// subAddressSplits returns every split of a local part at a configured delimiter into account and tag,
+// the longest account first
+func (s *Server) subAddressSplits(localPart string) [][2]string {
+	var splits [][2]string
+	for i := len(localPart) - 1; i > 0; i-- {
+		for _, delimiter := range s.config.SmtpServer.SubAddressDelimiters {
+			if delimiter != "" && strings.HasPrefix(localPart[i:], delimiter) {
+				splits = append(splits, [2]string{localPart[:i], localPart[i+len(delimiter):]})
+				break
+			}
+		}
+	}
+	return splits
+}
+
+// splitSubAddress splits a local part at the earliest configured delimiter into account and tag,
+// which is how new accounts are named; existing accounts are found through subAddressSplits
+func (s *Server) splitSubAddress(localPart string) (string, string) {
+	base, tag := localPart, ""
+	for _, delimiter := range s.config.SmtpServer.SubAddressDelimiters {
+		if delimiter == "" {
+			continue
+		}
+		if i := strings.Index(localPart, delimiter); i > 0 && i < len(base) {
+			base, tag = localPart[:i], localPart[i+len(delimiter):]
 		}
 	}
+	return base, tag
+}
+
+// newEmail builds the database record of a parsed message for one recipient
+func newEmail(msg *Message, accountID, from, recipient string) *db.Email {
+	email := &db.Email{
+		AccountID: accountID,
+		From:      msg.From,
+		To:        recipient,
+		Cc:        msg.Cc,
+		Subject:   msg.Subject,
+		MessageID: msg.MessageID,
+		Text:      msg.Text,
+		HTML:      msg.HTML,
+		Snippet:   msg.Snippet(),
+	}
+
+	if email.From == "" {
+		email.From = from
+	}
+
+	if !msg.Date.IsZero() {
+		sentAt := msg.Date
+		email.SentAt = &sentAt
+	}
+
+	for _, h := range msg.Headers {
+		email.Headers = append(email.Headers, db.EmailHeader{Name: h.Name, Value: h.Value})
+	}
+
+	for _, a := range msg.Attachments {
+		checksum := sha256.Sum256(a.Data)
+		email.Attachments = append(email.Attachments, db.Attachment{
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Size:        int64(len(a.Data)),
+			ContentID:   a.ContentID,
+			Inline:      a.Inline,
+			Checksum:    hex.EncodeToString(checksum[:]),
+			Data:        a.Data,
+		})
+	}
+
+	items := extract.Extract(extract.Content{
+		Subject:         msg.Subject,
+		Text:            msg.Text,
+		HTML:            msg.HTML,
+		ListUnsubscribe: msg.Header("List-Unsubscribe"),
+	})
+	for _, item := range items[:min(len(items), maxExtractions)] {
+		email.Extractions = append(email.Extractions, db.Extraction{Kind: string(item.Kind), Value: item.Value, Text: item.Text})
+	}
+
+	return email
+}
+
+// maxExtractions bounds the codes and links stored per email, for messages full of links
+const maxExtractions = 50
+
+var (
+	errAccountNotFound   = errors.New("account not found")
+	errConnectionDropped = errors.New("connection dropped")
+	// errRecipientRejected is answered with the default 550 of smtpd
+	errRecipientRejected = errors.New("recipient rejected")
+)
+
+// receivedFromRE matches the trace header smtpd prepends, capturing the HELO name
+var receivedFromRE = regexp.MustCompile(`^Received: from (\S*) \(`)
+
+// heloName extracts the HELO/EHLO name the client greeted with
+func heloName(data []byte) string {
+	line, _, _ := bytes.Cut(data, []byte("\r\n"))
+	if match := receivedFromRE.FindSubmatch(line); match != nil {
+		return string(match[1])
+	}
 	return ""
 }
 
+// remoteIP returns the IP part of a remote address
+func remoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
 func NewServer(config *config.Config, db *db.DB) *Server {
-	svc := &Server{config: config, db: db}
+	svc := &Server{config: config, db: db, faults: fault.Default(), limits: newLimiter(config.SmtpServer.RateLimit)}
+	svc.conns = newConnections(svc.limits)
+
+	if err := svc.faults.Configure(config.SmtpServer.Faults); err != nil {
+		log.Error("Invalid fault injection settings, fault injection disabled: %v", err)
+	}
+
+	// The server-wide size limit is the largest domain limit, or unlimited if any domain is
+	maxSize, unlimited := 0, false
+	for _, domain := range config.Domains() {
+		policy := &domainPolicy{Domain: domain}
+
+		if domain.CatchAllEnabled() && domain.CatchAllPattern != "" {
+			re, err := regexp.Compile(domain.CatchAllPattern)
+			if err != nil {
+				log.Error("Invalid catch-all pattern %q for %s, catch-all disabled: %v", domain.CatchAllPattern, domain.Name, err)
+				disabled := false
+				policy.CatchAll = &disabled
+			} else {
+				policy.catchAllPattern = re
+			}
+		}
+
+		if domain.MaxSize <= 0 {
+			unlimited = true
+		} else {
+			maxSize = max(maxSize, domain.MaxSize)
+		}
+
+		svc.domains = append(svc.domains, policy)
+	}
+	if unlimited {
+		maxSize = 0
+	}
 
 	// Create SMTP server
 	svc.srv = &smtpd.Server{
-		Addr:        fmt.Sprintf("%s:%s", config.SmtpServer.Host, config.SmtpServer.Port),
-		Handler:     svc.handleMail,
-		Appname:     config.SmtpServer.AppName,
-		Hostname:    config.SmtpServer.Hostname,
-		MaxSize:     config.SmtpServer.MaxSize,
-		AuthHandler: nil, // No authentication for temporary mail
+		Addr:             fmt.Sprintf("%s:%s", config.SmtpServer.Host, config.SmtpServer.Port),
+		Handler:          svc.handleMail,
+		HandlerMailError: svc.handleMailFrom,
+		HandlerRcptError: svc.handleRcpt,
+		ReplyDelay:       svc.faults.Latency,
+		Appname:          config.SmtpServer.AppName,
+		Hostname:         config.SmtpServer.Hostname,
+		MaxSize:          maxSize,
+	}
+
+	// Authentication is optional, temporary mail is open to anyone by default
+	if config.SmtpServer.Auth {
+		svc.auth = newAuthenticator(config.SmtpServer.AllCredentials(), svc.conns)
+		svc.srv.AuthHandler = svc.auth.handleAuth
+		svc.srv.AuthMechs = authMechanisms(config.SmtpServer.AuthMechanisms, config.SmtpServer.AuthAllowInsecure)
+		svc.srv.AuthRequired = config.SmtpServer.AuthRequired
 	}
 
 	return svc
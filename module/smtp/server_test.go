@@ -0,0 +1,125 @@
+package smtp
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/galihrivanto/kotak/config"
+	"github.com/galihrivanto/kotak/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitSubAddress(t *testing.T) {
+	testCases := []struct {
+		name       string
+		delimiters []string
+		localPart  string
+		base       string
+		tag        string
+	}{
+		{name: "disabled", localPart: "alice+checkout", base: "alice+checkout"},
+		{name: "plus", delimiters: []string{"+"}, localPart: "alice+checkout", base: "alice", tag: "checkout"},
+		{name: "earliest delimiter wins", delimiters: []string{"+", "-"}, localPart: "alice-a+b", base: "alice", tag: "a+b"},
+		{name: "no delimiter", delimiters: []string{"+"}, localPart: "alice", base: "alice"},
+		{name: "leading delimiter ignored", delimiters: []string{"+"}, localPart: "+alice", base: "+alice"},
+		{name: "empty tag", delimiters: []string{"+"}, localPart: "alice+", base: "alice"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Server{config: &config.Config{SmtpServer: config.SmtpServer{SubAddressDelimiters: tc.delimiters}}}
+			base, tag := s.splitSubAddress(tc.localPart)
+			assert.Equal(t, tc.base, base)
+			assert.Equal(t, tc.tag, tag)
+		})
+	}
+}
+
+func TestLookupAccountHyphenatedNames(t *testing.T) {
+	database, err := db.New(config.Database{Driver: "sqlite", Database: filepath.Join(t.TempDir(), "kotak")})
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+
+	for _, id := range []string{"signup", "signup-test-jane", "brave-otter-42"} {
+		require.NoError(t, database.CreateAccount(&db.Account{ID: id, Domain: "kotak.test"}))
+	}
+
+	s := &Server{
+		config: &config.Config{SmtpServer: config.SmtpServer{SubAddressDelimiters: []string{"+", "-"}}},
+		db:     database,
+	}
+	policy := &domainPolicy{Domain: config.Domain{Name: "kotak.test"}}
+
+	testCases := []struct {
+		localPart string
+		id        string
+		tag       string
+	}{
+		{"signup-test-jane", "signup-test-jane", ""},
+		{"signup-test-jane+x", "signup-test-jane", "x"},
+		{"signup-test-jane-a+b", "signup-test-jane", "a+b"},
+		{"signup-other", "signup", "other"},
+		{"brave-otter-42+checkout", "brave-otter-42", "checkout"},
+		{"Signup-Test-Jane+Invite", "signup-test-jane", "Invite"},
+		{"BRAVE-OTTER-42", "brave-otter-42", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.localPart, func(t *testing.T) {
+			id, tag, ok, err := s.lookupAccount(tc.localPart, policy)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, tc.id, id)
+			assert.Equal(t, tc.tag, tag)
+		})
+	}
+
+	_, _, ok, err := s.lookupAccount("brave-lion-7", policy)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Expired accounts receive no mail while they wait for cleanup
+	expired := time.Now().Add(-time.Minute)
+	require.NoError(t, database.CreateAccount(&db.Account{ID: "lapsed", Domain: "kotak.test", ExpiresAt: &expired}))
+	_, _, ok, err = s.lookupAccount("lapsed+x", policy)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestResolveAccountCatchAllHyphenatedName(t *testing.T) {
+	database, err := db.New(config.Database{Driver: "sqlite", Database: filepath.Join(t.TempDir(), "kotak")})
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.CreateAccount(&db.Account{ID: "build", Domain: "kotak.test"}))
+
+	s := &Server{
+		config: &config.Config{SmtpServer: config.SmtpServer{SubAddressDelimiters: []string{"+", "-"}}},
+		db:     database,
+	}
+	catchAll := true
+	policy := &domainPolicy{
+		Domain:          config.Domain{Name: "kotak.test", CatchAll: &catchAll},
+		catchAllPattern: regexp.MustCompile(`^build-[0-9]+$`),
+	}
+	s.domains = []*domainPolicy{policy}
+
+	// The whole local part matches the pattern, so it is not delivered to build with tag 1234
+	id, tag, err := s.resolveAccount("Build-1234", policy)
+	require.NoError(t, err)
+	assert.Equal(t, "build-1234", id)
+	assert.Empty(t, tag)
+	assert.Equal(t, []string{"build-1234@kotak.test"}, s.recipientKeys([]string{"build-1234@kotak.test"}))
+
+	id, tag, err = s.resolveAccount("build-1234+ci", policy)
+	require.NoError(t, err)
+	assert.Equal(t, "build-1234", id)
+	assert.Equal(t, "ci", tag)
+
+	id, tag, err = s.resolveAccount("build-nightly", policy)
+	require.NoError(t, err)
+	assert.Equal(t, "build", id)
+	assert.Equal(t, "nightly", tag)
+}
@@ -0,0 +1,66 @@
+package smtp
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/galihrivanto/kotak/config"
+	"github.com/galihrivanto/kotak/db"
+)
+
+// tlsVersions maps the configurable minimum versions to their protocol constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig loads the server certificate and records the negotiated state of every handshake
+func newTLSConfig(cfg config.SmtpServer, conns *connections) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SMTP certificate: %w", err)
+	}
+
+	minVersion := uint16(tls.VersionTLS12)
+	if cfg.TLSMinVersion != "" {
+		v, ok := tlsVersions[cfg.TLSMinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported SMTP TLS minimum version %q", cfg.TLSMinVersion)
+		}
+		minVersion = v
+	}
+
+	base := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+	}
+
+	return &tls.Config{
+		Certificates: base.Certificates,
+		MinVersion:   base.MinVersion,
+		// A per-connection config is the only place the handshake result can be tied to the remote address
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			state := conns.get(hello.Conn.RemoteAddr())
+			perConn := base.Clone()
+			perConn.VerifyConnection = func(cs tls.ConnectionState) error {
+				state.setTLS(cs)
+				return nil
+			}
+			return perConn, nil
+		},
+	}, nil
+}
+
+// tlsEnvelope fills the TLS details of an envelope from a connection state
+func tlsEnvelope(envelope *db.Envelope, state *tls.ConnectionState) {
+	if state == nil {
+		return
+	}
+
+	envelope.TLS = true
+	envelope.TLSVersion = tls.VersionName(state.Version)
+	envelope.TLSCipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	envelope.TLSServerName = state.ServerName
+}